@@ -0,0 +1,161 @@
+package bingodb
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+const (
+	SUM = "sum"
+	MIN = "min"
+	MAX = "max"
+	AVG = "avg"
+)
+
+// Aggregation computes Op over Field of the documents, for each value of
+// GroupBy if given. Documents missing Field, or holding a value Op cannot
+// use, are left out.
+type Aggregation struct {
+	Op      string
+	Field   string
+	GroupBy string
+}
+
+// AggregateResult is the value of an aggregation over Count documents,
+// of the documents with Group as the value of GroupBy when grouped.
+type AggregateResult struct {
+	Group interface{}
+	Value interface{}
+	Count int64
+}
+
+func (aggregation *Aggregation) validate() error {
+	switch aggregation.Op {
+	case SUM, MIN, MAX, AVG:
+	default:
+		return fmt.Errorf(UnknownAggregation, aggregation.Op)
+	}
+	if len(aggregation.Field) == 0 {
+		return errors.New(AggregateFieldMissing)
+	}
+	return nil
+}
+
+// Aggregate computes aggregation over the documents of a partition of the
+// index in the range of options matching its filter. Results are ordered
+// by group, documents without the GroupBy field in the first group.
+func (table *Table) Aggregate(indexName string, hash interface{}, options *ScanOptions, aggregation *Aggregation) ([]*AggregateResult, error) {
+	index := table.Index(indexName)
+	if index == nil {
+		return nil, errors.New(IndexNotFound)
+	}
+	if err := aggregation.validate(); err != nil {
+		return nil, err
+	}
+
+	docs, err := documents(index, hash, options)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make(map[interface{}]*AggregateResult)
+	results := make([]*AggregateResult, 0)
+	if len(aggregation.GroupBy) == 0 {
+		results = append(results, &AggregateResult{})
+	}
+
+	for _, doc := range docs {
+		var result *AggregateResult
+		if len(aggregation.GroupBy) == 0 {
+			result = results[0]
+		} else {
+			group := groupKey(doc.Fetch(aggregation.GroupBy))
+			if result = groups[group]; result == nil {
+				result = &AggregateResult{Group: doc.Fetch(aggregation.GroupBy)}
+				groups[group] = result
+				results = append(results, result)
+			}
+		}
+		aggregation.add(result, doc.Fetch(aggregation.Field))
+	}
+
+	for _, result := range results {
+		if aggregation.Op == AVG && result.Count > 0 {
+			result.Value = toFloat(result.Value) / float64(result.Count)
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		a, b := results[i].Group, results[j].Group
+		if a == nil || b == nil {
+			return a == nil && b != nil
+		}
+		if diff, ok := compareValues(a, b); ok {
+			return diff < 0
+		}
+		return fmt.Sprint(a) < fmt.Sprint(b)
+	})
+
+	return results, nil
+}
+
+func (aggregation *Aggregation) add(result *AggregateResult, value interface{}) {
+	if value == nil {
+		return
+	}
+
+	switch aggregation.Op {
+	case SUM, AVG:
+		number, ok := toNumber(value)
+		if !ok {
+			return
+		}
+		if result.Value == nil {
+			result.Value = number
+		} else {
+			result.Value, _ = addNumbers(result.Value, number)
+		}
+
+	case MIN, MAX:
+		if number, ok := toNumber(value); ok {
+			value = number
+		}
+		if _, ok := compareValues(value, value); !ok {
+			return
+		}
+		if result.Value != nil {
+			diff, ok := compareValues(value, result.Value)
+			if !ok {
+				return
+			}
+			if (aggregation.Op == MIN && diff < 0) || (aggregation.Op == MAX && diff > 0) {
+				result.Value = value
+			}
+		} else {
+			result.Value = value
+		}
+	}
+	result.Count++
+}
+
+type numberGroup struct {
+	value float64
+}
+
+type compositeGroup struct {
+	value string
+}
+
+// groupKey makes a value usable as a map key, numbers of any
+// representation such as json.Number and int64 falling in one group.
+func groupKey(value interface{}) interface{} {
+	if number, ok := toNumber(value); ok {
+		return numberGroup{toFloat(number)}
+	}
+	switch value.(type) {
+	case []interface{}, map[string]interface{}, Data:
+		return compositeGroup{fmt.Sprint(value)}
+	}
+	return value
+}
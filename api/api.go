@@ -42,7 +42,7 @@ func NewBingoServer(bingo *bingodb.Bingo, middleware ...gin.HandlerFunc) *BingoS
 	}
 
 	fmt.Printf("* Preparing resources..\n")
-	resource := &Resource{bingo: bingo}
+	resource := &Resource{bingo: bingo, cursorKey: newCursorKey(bingo.ServerConfig)}
 
 	engine.GET("/ping", ping)
 	engine.GET("/version", version)
@@ -50,12 +50,31 @@ func NewBingoServer(bingo *bingodb.Bingo, middleware ...gin.HandlerFunc) *BingoS
 	engine.GET("/tables/:table", resource.Get)
 	engine.GET("/tables/:table/info", resource.TableInfo)
 	engine.GET("/tables/:table/scan", resource.Scan)
+	engine.GET("/tables/:table/all", resource.All)
+	engine.GET("/tables/:table/count", resource.Count)
+	engine.GET("/tables/:table/aggregate", resource.Aggregate)
+	engine.GET("/tables/:table/partitions", resource.Partitions)
+	engine.GET("/tables/:table/rank", resource.Rank)
 	engine.GET("/tables/:table/indices/:index", resource.Get)
 	engine.GET("/tables/:table/indices/:index/scan", resource.Scan)
-
-	engine.PUT("/tables/:table", resource.Put)
-
-	engine.DELETE("/tables/:table", resource.Remove)
+	engine.GET("/tables/:table/indices/:index/count", resource.Count)
+	engine.GET("/tables/:table/indices/:index/aggregate", resource.Aggregate)
+	engine.GET("/tables/:table/indices/:index/partitions", resource.Partitions)
+	engine.GET("/tables/:table/indices/:index/rank", resource.Rank)
+
+	engine.PUT("/tables/:table", resource.Idempotent, resource.Put)
+	engine.PUT("/tables/:table/indices/:index", resource.Idempotent, resource.PutByIndex)
+
+	engine.POST("/batch", resource.Idempotent, resource.Batch)
+	engine.POST("/transact", resource.Idempotent, resource.Transact)
+	engine.POST("/tables/:table/batch", resource.Idempotent, resource.Batch)
+	engine.POST("/tables/:table/get", resource.MultiGet)
+	engine.POST("/tables/:table/indices/:index/get", resource.MultiGet)
+
+	engine.DELETE("/tables/:table", resource.Idempotent, resource.Remove)
+	engine.DELETE("/tables/:table/range", resource.Idempotent, resource.RemoveRange)
+	engine.DELETE("/tables/:table/indices/:index", resource.Idempotent, resource.RemoveByIndex)
+	engine.DELETE("/tables/:table/indices/:index/range", resource.Idempotent, resource.RemoveRange)
 
 	time.Sleep(50000000)
 
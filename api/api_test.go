@@ -1,14 +1,25 @@
 package api
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"github.com/gavv/httpexpect"
+	"github.com/gin-gonic/gin"
 	"github.com/zoyi/bingodb"
 	"net/http"
 	"strings"
 	"testing"
 )
 
+// followNext requests the page at `next` of a scan response.
+func followNext(expector *httpexpect.Expect, path string, obj *httpexpect.Object) *httpexpect.Object {
+	return expector.
+		GET(path).
+		WithQuery("cursor", obj.Value("next").Raw()).
+		Expect().Status(http.StatusOK).
+		JSON().Object()
+}
+
 func initDefaultSeedData(bingo *bingodb.Bingo) {
 	table, _ := bingo.Table("onlines")
 	{
@@ -201,7 +212,7 @@ func TestScanWithValidParams(t *testing.T) {
 
 	obj.Value("values").Array().Length().Equal(1)
 	obj.Value("values").Array().Element(0).Object().Value("personKey").Equal("person1")
-	obj.Value("next").Equal("person2")
+	followNext(expector, "/tables/onlines/scan", obj).Value("values").Array().Element(0).Object().Value("personKey").Equal("person2")
 
 	obj = expector.
 		GET("/tables/onlines/scan").
@@ -213,7 +224,7 @@ func TestScanWithValidParams(t *testing.T) {
 
 	obj.Value("values").Array().Length().Equal(1)
 	obj.Value("values").Array().Element(0).Object().Value("personKey").Equal("person2")
-	obj.Value("next").Equal("person3")
+	followNext(expector, "/tables/onlines/scan", obj).Value("values").Array().Element(0).Object().Value("personKey").Equal("person3")
 
 	obj = expector.
 		GET("/tables/onlines/scan").
@@ -226,17 +237,36 @@ func TestScanWithValidParams(t *testing.T) {
 
 	obj.Value("values").Array().Length().Equal(1)
 	obj.Value("values").Array().Element(0).Object().Value("personKey").Equal("person2")
-	obj.Value("next").Equal("person1")
+	followNext(expector, "/tables/onlines/scan", obj).Value("values").Array().Element(0).Object().Value("personKey").Equal("person1")
 
 }
 
 func TestScanWithInvalidParams(t *testing.T) {
-	getExpector(t).
+	expector := getExpector(t)
+
+	expector.
 		GET("/tables/onlines/scan").
 		WithQuery("limit", "20").
 		Expect().Status(http.StatusOK).
 		JSON().Object().
 		Value("values").Array().Empty()
+
+	for _, limit := range []string{"0", "-1", "dummy"} {
+		expector.
+			GET("/tables/onlines/scan").
+			WithQuery("hash", "1").
+			WithQuery("limit", limit).
+			Expect().Status(http.StatusUnprocessableEntity).
+			JSON().Array().Element(0).Object().ValueEqual("error", InvalidLimit)
+
+		expector.
+			GET("/tables/onlines/scan").
+			WithQuery("hash", "1").
+			WithQuery("hash", "2").
+			WithQuery("limit", limit).
+			Expect().Status(http.StatusUnprocessableEntity).
+			JSON().Array().Element(0).Object().ValueEqual("error", InvalidLimit)
+	}
 }
 
 func TestScanIndexWithValidParams(t *testing.T) {
@@ -263,11 +293,7 @@ func TestScanIndexWithValidParams(t *testing.T) {
 
 	obj.Value("values").Array().Length().Equal(1)
 	obj.Value("values").Array().Element(0).Object().Value("personKey").Equal("person3")
-	subSortNext := obj.Value("next").Array()
-	subSortNext.Length().Equal(3)
-	subSortNext.Element(0).Equal(1600000000000)
-	subSortNext.Element(1).Equal("1")
-	subSortNext.Element(2).Equal("person2")
+	followNext(expector, "/tables/onlines/indices/guest/scan", obj).Value("values").Array().Element(0).Object().Value("personKey").Equal("person2")
 
 	obj = expector.
 		GET("/tables/onlines/indices/guest/scan").
@@ -280,11 +306,7 @@ func TestScanIndexWithValidParams(t *testing.T) {
 	obj.Value("values").Array().Length().Equal(1)
 	obj.Value("values").Array().Element(0).Object().Value("personKey").Equal("person2")
 
-	subSortNext = obj.Value("next").Array()
-	subSortNext.Length().Equal(3)
-	subSortNext.Element(0).Equal(1700000000000)
-	subSortNext.Element(1).Equal("1")
-	subSortNext.Element(2).Equal("person1")
+	followNext(expector, "/tables/onlines/indices/guest/scan", obj).Value("values").Array().Element(0).Object().Value("personKey").Equal("person1")
 
 	obj = expector.
 		GET("/tables/onlines/indices/guest/scan").
@@ -298,11 +320,7 @@ func TestScanIndexWithValidParams(t *testing.T) {
 	obj.Value("values").Array().Length().Equal(1)
 	obj.Value("values").Array().Element(0).Object().Value("personKey").Equal("person2")
 
-	subSortNext = obj.Value("next").Array()
-	subSortNext.Length().Equal(3)
-	subSortNext.Element(0).Equal(1500000000000)
-	subSortNext.Element(1).Equal("1")
-	subSortNext.Element(2).Equal("person3")
+	followNext(expector, "/tables/onlines/indices/guest/scan", obj).Value("values").Array().Element(0).Object().Value("personKey").Equal("person3")
 
 	obj = expector.
 		GET("/tables/onlines/indices/guest/scan").
@@ -469,42 +487,1746 @@ func TestPutWithInvalidParams(t *testing.T) {
 		Expect().Status(http.StatusUnprocessableEntity)
 }
 
-func TestDeleteWithValidParams(t *testing.T) {
+func TestPutWithOperators(t *testing.T) {
+	expector := getExpector(t)
+
+	key := map[string]interface{}{"channelId": "1", "personKey": "person1"}
+
+	for _, expected := range []int{1, 2} {
+		expector.
+			PUT("/tables/onlines").
+			WithJSON(map[string]interface{}{
+				"$set": key,
+				"$inc": map[string]interface{}{"unread": 1},
+			}).
+			Expect().Status(http.StatusOK).
+			JSON().Object().Value("new").Object().
+			ValueEqual("unread", expected)
+	}
+
+	expector.
+		PUT("/tables/onlines").
+		WithJSON(map[string]interface{}{
+			"$set": key,
+			"$max": map[string]interface{}{"updatedAt": 1800000000000},
+			"$min": map[string]interface{}{"lastSeen": 100},
+		}).
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("new").Object().
+		ValueEqual("updatedAt", 1800000000000).
+		ValueEqual("lastSeen", 100)
+
+	expector.
+		PUT("/tables/onlines").
+		WithJSON(map[string]interface{}{
+			"$set": key,
+			"$max": map[string]interface{}{"updatedAt": 1000},
+			"$min": map[string]interface{}{"lastSeen": 200},
+		}).
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("new").Object().
+		ValueEqual("updatedAt", 1800000000000).
+		ValueEqual("lastSeen", 100)
+
+	expector.
+		PUT("/tables/onlines").
+		WithJSON(map[string]interface{}{
+			"$set":   key,
+			"$unset": map[string]interface{}{"updatedAt": ""},
+		}).
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("new").Object().
+		NotContainsKey("updatedAt")
+
+	expector.
+		GET("/tables/onlines/indices/guest/scan").
+		WithQuery("hash", "1").
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("values").
+		Array().Length().Equal(2)
+
+	expector.
+		GET("/tables/onlines/info").
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("subIndices").Object().
+		ValueEqual("guest", 2)
+
+	set := map[string]interface{}{"channelId": "2", "personKey": "person1", "expiresAt": 2800000000000}
+	expector.
+		PUT("/tables/onlines").
+		WithJSON(map[string]interface{}{
+			"$set": set,
+			"$inc": map[string]interface{}{"unread": 3},
+			"$max": map[string]interface{}{"updatedAt": 1800000000000},
+		}).
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("new").Object().
+		ValueEqual("unread", 3).
+		ValueEqual("updatedAt", 1800000000000)
+}
+
+func TestPutWithInvalidOperators(t *testing.T) {
+	expector := getExpector(t)
+
+	key := map[string]interface{}{"channelId": "1", "personKey": "person1"}
+
+	expector.
+		PUT("/tables/onlines").
+		WithJSON(map[string]interface{}{
+			"$set": key,
+			"$inc": map[string]interface{}{"personKey": 1},
+		}).
+		Expect().Status(http.StatusUnprocessableEntity)
+
+	expector.
+		PUT("/tables/onlines").
+		WithJSON(map[string]interface{}{
+			"$set": key,
+			"$inc": map[string]interface{}{"unread": "one"},
+		}).
+		Expect().Status(http.StatusUnprocessableEntity)
+
+	expector.
+		PUT("/tables/onlines").
+		WithJSON(map[string]interface{}{
+			"$set":   key,
+			"$unset": map[string]interface{}{"channelId": ""},
+		}).
+		Expect().Status(http.StatusUnprocessableEntity)
+
+	expector.
+		PUT("/tables/onlines").
+		WithJSON(map[string]interface{}{
+			"$set": key,
+			"$max": map[string]interface{}{"lastSeen": "never"},
+		}).
+		Expect().Status(http.StatusUnprocessableEntity)
+
+	expector.
+		GET("/tables/onlines").
+		WithQuery("hash", "1").
+		WithQuery("sort", "person1").
+		Expect().Status(http.StatusOK).
+		JSON().Object().
+		ValueEqual("lastSeen", 123)
+}
+
+func TestPutWithArrayOperators(t *testing.T) {
+	expector := getExpector(t)
+
+	key := map[string]interface{}{"channelId": "1", "personKey": "person1"}
+
+	expector.
+		PUT("/tables/onlines").
+		WithJSON(map[string]interface{}{
+			"$set":  key,
+			"$push": map[string]interface{}{"sockets": "soc1"},
+		}).
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("new").Object().
+		Value("sockets").Array().Equal([]interface{}{"soc1"})
+
+	expector.
+		PUT("/tables/onlines").
+		WithJSON(map[string]interface{}{
+			"$set": key,
+			"$push": map[string]interface{}{
+				"sockets": map[string]interface{}{
+					"$each":  []interface{}{"soc2", "soc3", "soc4"},
+					"$slice": -3,
+				},
+			},
+		}).
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("new").Object().
+		Value("sockets").Array().Equal([]interface{}{"soc2", "soc3", "soc4"})
+
+	expector.
+		PUT("/tables/onlines").
+		WithJSON(map[string]interface{}{
+			"$set":      key,
+			"$addToSet": map[string]interface{}{"sockets": map[string]interface{}{"$each": []interface{}{"soc3", "soc5"}}},
+			"$pull":     map[string]interface{}{"sockets": "soc2"},
+		}).
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("new").Object().
+		Value("sockets").Array().Equal([]interface{}{"soc3", "soc4", "soc5"})
+
+	expector.
+		PUT("/tables/onlines").
+		WithJSON(map[string]interface{}{
+			"$set":  key,
+			"$pull": map[string]interface{}{"sockets": map[string]interface{}{"$in": []interface{}{"soc3", "soc5"}}},
+		}).
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("new").Object().
+		Value("sockets").Array().Equal([]interface{}{"soc4"})
+
+	expector.
+		PUT("/tables/onlines").
+		WithJSON(map[string]interface{}{
+			"$set":  key,
+			"$push": map[string]interface{}{"updatedAt": 1},
+		}).
+		Expect().Status(http.StatusUnprocessableEntity)
+
+	expector.
+		PUT("/tables/onlines").
+		WithJSON(map[string]interface{}{
+			"$set":  key,
+			"$push": map[string]interface{}{"lastSeen": 1},
+		}).
+		Expect().Status(http.StatusUnprocessableEntity)
+
+	expector.
+		PUT("/tables/onlines").
+		WithJSON(map[string]interface{}{
+			"$set":  key,
+			"$push": map[string]interface{}{"sockets": map[string]interface{}{"$slice": 1}},
+		}).
+		Expect().Status(http.StatusUnprocessableEntity)
+}
+
+func TestPutWithCondition(t *testing.T) {
+	expector := getExpector(t)
+
+	set := map[string]interface{}{"channelId": "1", "personKey": "person4", "expiresAt": 2800000000000}
+
+	obj := expector.
+		PUT("/tables/onlines").
+		WithJSON(map[string]interface{}{
+			"$set": set,
+			"$if":  map[string]interface{}{"$exists": true},
+		}).
+		Expect().Status(http.StatusConflict).
+		JSON().Object()
+
+	obj.Value("error").Equal("condition failed")
+	obj.Value("current").Null()
+
+	expector.
+		GET("/tables/onlines").
+		WithQuery("hash", "1").
+		WithQuery("sort", "person4").
+		Expect().Status(http.StatusUnprocessableEntity)
+
+	set = map[string]interface{}{"channelId": "1", "personKey": "person1", "updatedAt": 1800000000000}
+
+	expector.
+		PUT("/tables/onlines").
+		WithJSON(map[string]interface{}{
+			"$set": set,
+			"$if":  map[string]interface{}{"updatedAt": map[string]interface{}{"$lt": 1800000000000}},
+		}).
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("new").Object().
+		ValueEqual("updatedAt", 1800000000000)
+
+	obj = expector.
+		PUT("/tables/onlines").
+		WithJSON(map[string]interface{}{
+			"$set": set,
+			"$if":  map[string]interface{}{"updatedAt": map[string]interface{}{"$lt": 1800000000000}},
+		}).
+		Expect().Status(http.StatusConflict).
+		JSON().Object()
+
+	obj.Value("current").Object().ValueEqual("updatedAt", 1800000000000)
+
+	expector.
+		PUT("/tables/onlines").
+		WithJSON(map[string]interface{}{
+			"$set": set,
+			"$if":  map[string]interface{}{"$exists": true, "lastSeen": 123},
+		}).
+		Expect().Status(http.StatusOK)
+
+	expector.
+		PUT("/tables/onlines").
+		WithJSON(map[string]interface{}{
+			"$set": set,
+			"$if":  map[string]interface{}{"updatedAt": map[string]interface{}{"$near": 1}},
+		}).
+		Expect().Status(http.StatusUnprocessableEntity)
+}
+
+func TestDeleteWithCondition(t *testing.T) {
 	expector := getExpector(t)
 
 	expector.
 		DELETE("/tables/onlines").
 		WithQuery("hash", "1").
 		WithQuery("sort", "person1").
+		WithQuery("if", `{"updatedAt": {"$lt": 1600000000000}}`).
+		Expect().Status(http.StatusConflict).
+		JSON().Object().Value("current").Object().
+		ValueEqual("personKey", "person1")
+
+	expector.
+		DELETE("/tables/onlines").
+		WithQuery("hash", "1").
+		WithQuery("sort", "person1").
+		WithQuery("if", "{ dummy }").
+		Expect().Status(http.StatusUnprocessableEntity)
+
+	expector.
+		DELETE("/tables/onlines").
+		WithQuery("hash", "1").
+		WithQuery("sort", "person1").
+		WithQuery("if", `{"$exists": true, "updatedAt": 1700000000000}`).
 		Expect().Status(http.StatusOK).
 		JSON().Object().Value("personKey").Equal("person1")
+}
+
+func TestPutWithExpectedVersion(t *testing.T) {
+	expector := getExpector(t)
+
+	expector.
+		GET("/tables/onlines").
+		WithQuery("hash", "1").
+		WithQuery("sort", "person1").
+		Expect().Status(http.StatusOK).
+		Header("ETag").Equal(`"1"`)
+
+	expector.
+		GET("/tables/onlines").
+		WithQuery("hash", "1").
+		WithQuery("sort", "person1").
+		Expect().Status(http.StatusOK).
+		JSON().Object().
+		ValueEqual("_version", 1)
+
+	set := map[string]interface{}{"channelId": "1", "personKey": "person1", "lastSeen": 200}
+
+	expector.
+		PUT("/tables/onlines").
+		WithJSON(map[string]interface{}{"$set": set, "expectedVersion": 1}).
+		Expect().Status(http.StatusOK).
+		JSON().Object().
+		ValueEqual("version", 4)
+
+	expector.
+		GET("/tables/onlines").
+		WithQuery("hash", "1").
+		WithQuery("sort", "person1").
+		Expect().Status(http.StatusOK).
+		Header("ETag").Equal(`"4"`)
 
 	expector.
 		GET("/tables/onlines").
 		WithQuery("hash", "1").
 		WithQuery("sort", "person1").
+		Expect().Status(http.StatusOK).
+		JSON().Object().
+		ValueEqual("_version", 4).
+		ValueEqual("lastSeen", 200)
+
+	expector.
+		PUT("/tables/onlines").
+		WithJSON(map[string]interface{}{"$set": set, "expectedVersion": 1}).
+		Expect().Status(http.StatusConflict).
+		JSON().Object().Value("current").Object().
+		ValueEqual("lastSeen", 200)
+
+	set = map[string]interface{}{"channelId": "1", "personKey": "person5", "expiresAt": 2800000000000}
+
+	expector.
+		PUT("/tables/onlines").
+		WithJSON(map[string]interface{}{"$set": set, "expectedVersion": 0}).
+		Expect().Status(http.StatusOK).
+		JSON().Object().
+		ValueEqual("version", 5)
+
+	expector.
+		PUT("/tables/onlines").
+		WithJSON(map[string]interface{}{"$set": set, "expectedVersion": 0}).
+		Expect().Status(http.StatusConflict)
+
+	expector.
+		DELETE("/tables/onlines").
+		WithQuery("hash", "1").
+		WithQuery("sort", "person1").
+		WithQuery("expectedVersion", 1).
+		Expect().Status(http.StatusConflict)
+
+	expector.
+		DELETE("/tables/onlines").
+		WithQuery("hash", "1").
+		WithQuery("sort", "person1").
+		WithQuery("expectedVersion", "dummy").
 		Expect().Status(http.StatusUnprocessableEntity)
 
 	expector.
-		GET("/tables/onlines/scan").
+		DELETE("/tables/onlines").
 		WithQuery("hash", "1").
-		WithQuery("limit", "20").
+		WithQuery("sort", "person1").
+		WithQuery("expectedVersion", 4).
+		Expect().Status(http.StatusOK)
+}
+
+func TestBatch(t *testing.T) {
+	expector := getExpector(t)
+
+	set := map[string]interface{}{"channelId": "1", "personKey": "person4", "expiresAt": 2800000000000}
+
+	obj := expector.
+		POST("/tables/onlines/batch").
+		WithJSON([]interface{}{
+			map[string]interface{}{"put": map[string]interface{}{"$set": set}},
+			map[string]interface{}{"delete": map[string]interface{}{"hash": "1", "sort": "person2"}},
+			map[string]interface{}{"delete": map[string]interface{}{"hash": "1", "sort": "person9"}},
+			map[string]interface{}{"delete": map[string]interface{}{"hash": "1", "sort": "person3", "expectedVersion": 1}},
+			map[string]interface{}{},
+		}).
 		Expect().Status(http.StatusOK).
-		JSON().Object().Value("values").
-		Array().Length().Equal(2)
+		JSON().Object()
+
+	results := obj.Value("results").Array()
+	results.Length().Equal(5)
+
+	results.Element(0).Object().ValueEqual("status", http.StatusOK)
+	results.Element(0).Object().Value("body").Object().
+		ValueEqual("replaced", false).
+		Value("new").Object().ValueEqual("personKey", "person4")
+
+	results.Element(1).Object().ValueEqual("status", http.StatusOK)
+	results.Element(1).Object().Value("body").Object().ValueEqual("personKey", "person2")
+
+	results.Element(2).Object().ValueEqual("status", http.StatusUnprocessableEntity)
+	results.Element(2).Object().Value("body").Object().ValueEqual("error", "document not found")
+
+	results.Element(3).Object().ValueEqual("status", http.StatusConflict)
+	results.Element(3).Object().Value("body").Object().
+		Value("current").Object().ValueEqual("personKey", "person3")
+
+	results.Element(4).Object().ValueEqual("status", http.StatusUnprocessableEntity)
 
 	expector.
 		GET("/tables/onlines/scan").
 		WithQuery("hash", "1").
-		WithQuery("test", "20").
 		Expect().Status(http.StatusOK).
 		JSON().Object().Value("values").
-		Array().Length().Equal(2)
-}
+		Array().Length().Equal(3)
 
-func TestDeleteWithInvalidParams(t *testing.T) {
-	getExpector(t).
-		DELETE("/tables/onlines").
+	obj = expector.
+		POST("/batch").
+		WithJSON([]interface{}{
+			map[string]interface{}{"table": "tests", "put": map[string]interface{}{"$set": map[string]interface{}{"hash": 1, "sort": 1}}},
+			map[string]interface{}{"table": "onlines", "delete": map[string]interface{}{"hash": "1", "sort": "person4"}},
+			map[string]interface{}{"table": "wrong", "delete": map[string]interface{}{"hash": "1", "sort": "person1"}},
+		}).
+		Expect().Status(http.StatusOK).
+		JSON().Object()
+
+	results = obj.Value("results").Array()
+	results.Length().Equal(3)
+	results.Element(0).Object().ValueEqual("status", http.StatusOK)
+	results.Element(1).Object().ValueEqual("status", http.StatusOK)
+	results.Element(2).Object().ValueEqual("status", http.StatusUnprocessableEntity)
+
+	expector.
+		GET("/tables/tests").
 		WithQuery("hash", "1").
+		WithQuery("sort", "1").
+		Expect().Status(http.StatusOK)
+
+	expector.
+		POST("/batch").
+		WithJSON(map[string]interface{}{"put": nil}).
+		Expect().Status(http.StatusUnprocessableEntity)
+
+	expector.
+		POST("/tables/wrong/batch").
+		WithJSON([]interface{}{
+			map[string]interface{}{"delete": map[string]interface{}{"hash": "1", "sort": "person1"}},
+		}).
+		Expect().Status(http.StatusUnprocessableEntity).
+		JSON().Array().Element(0).Object().ValueEqual("error", TableNotFound)
+}
+
+func TestMultiGet(t *testing.T) {
+	expector := getExpector(t)
+
+	values := expector.
+		POST("/tables/onlines/get").
+		WithJSON([]interface{}{
+			map[string]interface{}{"hash": "1", "sort": "person3"},
+			map[string]interface{}{"hash": "1", "sort": "person9"},
+			map[string]interface{}{"hash": "1", "sort": "person1"},
+		}).
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("values").Array()
+
+	values.Length().Equal(3)
+	values.Element(0).Object().ValueEqual("personKey", "person3")
+	values.Element(1).Null()
+	values.Element(2).Object().ValueEqual("personKey", "person1")
+
+	values = expector.
+		POST("/tables/onlines/indices/guest/get").
+		WithJSON([]interface{}{
+			map[string]interface{}{"hash": "1", "sort": 1600000000000},
+			map[string]interface{}{"hash": "2", "sort": 1600000000000},
+		}).
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("values").Array()
+
+	values.Length().Equal(2)
+	values.Element(0).Object().ValueEqual("personKey", "person2")
+	values.Element(1).Null()
+
+	expector.
+		POST("/tables/onlines/get").
+		WithJSON([]interface{}{
+			map[string]interface{}{"sort": "person1"},
+		}).
+		Expect().Status(http.StatusUnprocessableEntity)
+
+	expector.
+		POST("/tables/onlines/indices/wrong/get").
+		WithJSON([]interface{}{}).
 		Expect().Status(http.StatusUnprocessableEntity)
 }
+
+func TestTransact(t *testing.T) {
+	expector := getExpector(t)
+
+	set := map[string]interface{}{"channelId": "1", "personKey": "person4", "updatedAt": 1800000000000, "expiresAt": 2800000000000}
+
+	version := expector.
+		PUT("/tables/onlines").
+		WithJSON(map[string]interface{}{"$set": map[string]interface{}{"channelId": "1", "personKey": "person1"}, "return": "none"}).
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("version").Raw().(float64)
+
+	obj := expector.
+		POST("/transact").
+		WithJSON([]interface{}{
+			map[string]interface{}{"table": "onlines", "put": map[string]interface{}{"$set": set}},
+			map[string]interface{}{"table": "tests", "delete": map[string]interface{}{"hash": 0, "sort": 0}},
+			map[string]interface{}{"table": "onlines", "put": map[string]interface{}{
+				"$set": map[string]interface{}{"channelId": "1", "personKey": "person1", "lastSeen": 200},
+			}},
+			map[string]interface{}{"table": "onlines", "delete": map[string]interface{}{"hash": "1", "sort": "person2"}},
+			map[string]interface{}{"table": "onlines", "put": map[string]interface{}{
+				"$set": map[string]interface{}{"channelId": "1", "personKey": "person4"},
+				"$if":  map[string]interface{}{"$exists": false},
+			}},
+		}).
+		Expect().Status(http.StatusConflict).
+		JSON().Object()
+
+	obj.ValueEqual("index", 4)
+	obj.ValueEqual("error", "condition failed")
+	obj.Value("current").Object().ValueEqual("personKey", "person4")
+
+	expector.
+		GET("/tables/onlines").
+		WithQuery("hash", "1").
+		WithQuery("sort", "person4").
+		Expect().Status(http.StatusUnprocessableEntity)
+
+	expector.
+		GET("/tables/onlines").
+		WithQuery("hash", "1").
+		WithQuery("sort", "person1").
+		Expect().Status(http.StatusOK).
+		JSON().Object().ValueEqual("lastSeen", 123)
+
+	expector.
+		GET("/tables/tests").
+		WithQuery("hash", "0").
+		WithQuery("sort", "0").
+		Expect().Status(http.StatusOK)
+
+	info := expector.
+		GET("/tables/onlines/info").
+		Expect().Status(http.StatusOK).
+		JSON().Object()
+
+	info.ValueEqual("size", 3)
+	info.Value("subIndices").Object().ValueEqual("guest", 3)
+
+	values := expector.
+		GET("/tables/onlines/indices/guest/scan").
+		WithQuery("hash", "1").
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("values").Array()
+
+	values.Length().Equal(3)
+	values.Element(1).Object().ValueEqual("personKey", "person2")
+
+	obj = expector.
+		POST("/transact").
+		WithJSON([]interface{}{
+			map[string]interface{}{"table": "onlines", "put": map[string]interface{}{"$set": set}},
+			map[string]interface{}{"table": "tests", "delete": map[string]interface{}{"hash": 0, "sort": 0}},
+		}).
+		Expect().Status(http.StatusOK).
+		JSON().Object()
+
+	obj.Value("results").Array().Length().Equal(2)
+	// The versions taken by the reverted transaction are given back
+	obj.Value("results").Array().Element(0).Object().Value("body").Object().ValueEqual("version", version+1)
+
+	expector.
+		GET("/tables/tests").
+		WithQuery("hash", "0").
+		WithQuery("sort", "0").
+		Expect().Status(http.StatusUnprocessableEntity)
+
+	expector.
+		POST("/transact").
+		WithJSON([]interface{}{
+			map[string]interface{}{"table": "wrong", "delete": map[string]interface{}{"hash": 0, "sort": 0}},
+		}).
+		Expect().Status(http.StatusUnprocessableEntity)
+
+	expector.
+		POST("/transact").
+		WithJSON([]interface{}{
+			map[string]interface{}{"table": "onlines", "put": map[string]interface{}{"$set": map[string]interface{}{"channelId": "1"}}},
+		}).
+		Expect().Status(http.StatusUnprocessableEntity).
+		JSON().Object().ValueEqual("index", 0)
+}
+
+func TestDeleteWithValidParams(t *testing.T) {
+	expector := getExpector(t)
+
+	expector.
+		DELETE("/tables/onlines").
+		WithQuery("hash", "1").
+		WithQuery("sort", "person1").
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("personKey").Equal("person1")
+
+	expector.
+		GET("/tables/onlines").
+		WithQuery("hash", "1").
+		WithQuery("sort", "person1").
+		Expect().Status(http.StatusUnprocessableEntity)
+
+	expector.
+		GET("/tables/onlines/scan").
+		WithQuery("hash", "1").
+		WithQuery("limit", "20").
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("values").
+		Array().Length().Equal(2)
+
+	expector.
+		GET("/tables/onlines/scan").
+		WithQuery("hash", "1").
+		WithQuery("test", "20").
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("values").
+		Array().Length().Equal(2)
+}
+
+func TestDeleteWithInvalidParams(t *testing.T) {
+	getExpector(t).
+		DELETE("/tables/onlines").
+		WithQuery("hash", "1").
+		Expect().Status(http.StatusUnprocessableEntity)
+}
+
+func TestDeleteRange(t *testing.T) {
+	expector := getExpector(t)
+
+	expector.
+		DELETE("/tables/onlines/range").
+		WithQuery("hash", "1").
+		WithQuery("since", "person2").
+		WithQuery("until", "person3").
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("count").Equal(2)
+
+	expector.
+		GET("/tables/onlines/scan").
+		WithQuery("hash", "1").
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("values").
+		Array().Length().Equal(1)
+
+	expector.
+		GET("/tables/onlines/indices/guest/scan").
+		WithQuery("hash", "1").
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("values").
+		Array().Length().Equal(1)
+
+	expector.
+		DELETE("/tables/onlines/range").
+		WithQuery("hash", "2").
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("count").Equal(0)
+}
+
+func TestDeleteRangeWithSubIndex(t *testing.T) {
+	expector := getExpector(t)
+
+	expector.
+		DELETE("/tables/onlines/indices/guest/range").
+		WithQuery("hash", "1").
+		WithQuery("until", "1600000000000").
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("count").Equal(2)
+
+	expector.
+		GET("/tables/onlines/scan").
+		WithQuery("hash", "1").
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("values").
+		Array().Element(0).Object().Value("personKey").Equal("person1")
+
+	expector.
+		DELETE("/tables/onlines/indices/guest/range").
+		WithQuery("hash", "1").
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("count").Equal(1)
+
+	expector.
+		DELETE("/tables/onlines/indices/unknown/range").
+		WithQuery("hash", "1").
+		Expect().Status(http.StatusUnprocessableEntity)
+
+	expector.
+		DELETE("/tables/onlines/range").
+		Expect().Status(http.StatusUnprocessableEntity)
+}
+
+func TestDeleteByIndex(t *testing.T) {
+	expector := getExpector(t)
+
+	expector.
+		DELETE("/tables/onlines/indices/guest").
+		WithQuery("hash", "1").
+		WithQuery("sort", "1600000000000").
+		WithQuery("return", "unknown").
+		Expect().Status(http.StatusUnprocessableEntity)
+
+	expector.
+		DELETE("/tables/onlines/indices/guest").
+		WithQuery("hash", "1").
+		WithQuery("sort", "1600000000000").
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("personKey").Equal("person2")
+
+	expector.
+		GET("/tables/onlines").
+		WithQuery("hash", "1").
+		WithQuery("sort", "person2").
+		Expect().Status(http.StatusUnprocessableEntity)
+
+	expector.
+		DELETE("/tables/onlines/indices/guest").
+		WithQuery("hash", "1").
+		WithQuery("sort", "1600000000000").
+		Expect().Status(http.StatusUnprocessableEntity)
+
+	expector.
+		PUT("/tables/tests").
+		WithJSON(map[string]interface{}{"$set": map[string]interface{}{"hash": 0, "sort": 1, "expiresAt": 2800000000000}}).
+		Expect().Status(http.StatusOK)
+
+	expector.
+		DELETE("/tables/tests/indices/index").
+		WithQuery("hash", "0").
+		WithQuery("all", "true").
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("values").
+		Array().Length().Equal(2)
+
+	expector.
+		GET("/tables/tests/scan").
+		WithQuery("hash", "0").
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("values").
+		Array().Length().Equal(0)
+
+	expector.
+		DELETE("/tables/tests/indices/index").
+		WithQuery("hash", "0").
+		WithQuery("all", "true").
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("values").
+		Array().Length().Equal(0)
+
+	values := expector.
+		DELETE("/tables/onlines/indices/guest").
+		WithQuery("hash", "1").
+		WithQuery("all", "true").
+		WithQuery("return", "diff").
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("values").Array()
+	values.Length().Equal(2)
+	values.Element(0).Object().ContainsKey("unset").NotContainsKey("personKey")
+
+	expector.
+		GET("/tables/onlines/scan").
+		WithQuery("hash", "1").
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("values").
+		Array().Length().Equal(0)
+
+	expector.
+		DELETE("/tables/onlines/indices/guest").
+		WithQuery("hash", "1").
+		WithQuery("sort", "1600000000000").
+		WithQuery("return", "none").
+		Expect().Status(http.StatusUnprocessableEntity)
+}
+
+func TestPutByIndex(t *testing.T) {
+	expector := getExpector(t)
+
+	result := expector.
+		PUT("/tables/onlines/indices/guest").
+		WithQuery("hash", "1").
+		WithQuery("sort", "1700000000000").
+		WithJSON(map[string]interface{}{"$set": map[string]interface{}{"lastSeen": 200}}).
+		Expect().Status(http.StatusOK).
+		JSON().Object()
+	result.Value("replaced").Equal(true)
+	result.Value("new").Object().Value("personKey").Equal("person1")
+	result.Value("new").Object().Value("lastSeen").Equal(200)
+
+	expector.
+		PUT("/tables/onlines/indices/guest").
+		WithQuery("hash", "1").
+		WithQuery("sort", "1").
+		WithJSON(map[string]interface{}{"$set": map[string]interface{}{"lastSeen": 200}}).
+		Expect().Status(http.StatusUnprocessableEntity)
+
+	expector.
+		PUT("/tables/onlines/indices/guest").
+		WithQuery("hash", "1").
+		WithQuery("sort", "1600000000000").
+		WithJSON(map[string]interface{}{"$if": map[string]interface{}{"lastSeen": 1}, "$set": map[string]interface{}{"lastSeen": 200}}).
+		Expect().Status(http.StatusConflict)
+
+	expector.
+		PUT("/tables/tests").
+		WithJSON(map[string]interface{}{"$set": map[string]interface{}{"hash": 0, "sort": 1, "expiresAt": 2800000000000}}).
+		Expect().Status(http.StatusOK)
+
+	results := expector.
+		PUT("/tables/tests/indices/index").
+		WithQuery("hash", "0").
+		WithQuery("all", "true").
+		WithJSON(map[string]interface{}{"$inc": map[string]interface{}{"count": 1}}).
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("results").Array()
+	results.Length().Equal(2)
+	results.Element(0).Object().Value("status").Equal(http.StatusOK)
+	results.Element(1).Object().Value("body").Object().Value("new").Object().Value("count").Equal(1)
+
+	expector.
+		GET("/tables/tests").
+		WithQuery("hash", "0").
+		WithQuery("sort", "1").
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("count").Equal(1)
+}
+
+func TestPutWithMode(t *testing.T) {
+	expector := getExpector(t)
+
+	expector.
+		PUT("/tables/onlines").
+		WithJSON(map[string]interface{}{
+			"mode": "insert",
+			"$set": map[string]interface{}{"channelId": "1", "personKey": "person1", "expiresAt": 2600000000000},
+		}).
+		Expect().Status(http.StatusConflict).
+		JSON().Object().Value("current").Object().Value("lastSeen").Equal(123)
+
+	expector.
+		PUT("/tables/onlines").
+		WithJSON(map[string]interface{}{
+			"mode": "insert",
+			"$set": map[string]interface{}{"channelId": "1", "personKey": "person9", "expiresAt": 2600000000000},
+		}).
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("replaced").Equal(false)
+
+	expector.
+		PUT("/tables/onlines").
+		WithJSON(map[string]interface{}{
+			"mode": "update",
+			"$set": map[string]interface{}{"channelId": "1", "personKey": "person10", "expiresAt": 2600000000000},
+		}).
+		Expect().Status(http.StatusUnprocessableEntity)
+
+	expector.
+		GET("/tables/onlines").
+		WithQuery("hash", "1").
+		WithQuery("sort", "person10").
+		Expect().Status(http.StatusUnprocessableEntity)
+
+	expector.
+		PUT("/tables/onlines").
+		WithJSON(map[string]interface{}{
+			"mode": "update",
+			"$set": map[string]interface{}{"channelId": "1", "personKey": "person1", "expiresAt": 2600000000000, "lastSeen": 200},
+		}).
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("new").Object().Value("lastSeen").Equal(200)
+
+	expector.
+		PUT("/tables/onlines").
+		WithJSON(map[string]interface{}{
+			"mode": "upsert",
+			"$set": map[string]interface{}{"channelId": "1", "personKey": "person1", "expiresAt": 2600000000000},
+		}).
+		Expect().Status(http.StatusUnprocessableEntity)
+}
+
+func TestPutWithReturn(t *testing.T) {
+	expector := getExpector(t)
+
+	set := map[string]interface{}{"channelId": "1", "personKey": "person1", "expiresAt": 2600000000000, "lastSeen": 200}
+
+	result := expector.
+		PUT("/tables/onlines").
+		WithJSON(map[string]interface{}{"$set": set, "return": "none"}).
+		Expect().Status(http.StatusOK).
+		JSON().Object()
+	result.NotContainsKey("old").NotContainsKey("new").NotContainsKey("diff")
+	result.Value("replaced").Equal(true)
+
+	expector.
+		PUT("/tables/onlines").
+		WithJSON(map[string]interface{}{"$set": set, "return": "new"}).
+		Expect().Status(http.StatusOK).
+		JSON().Object().NotContainsKey("old").
+		Value("new").Object().Value("lastSeen").Equal(200)
+
+	expector.
+		PUT("/tables/onlines").
+		WithJSON(map[string]interface{}{"$set": set, "return": "old"}).
+		Expect().Status(http.StatusOK).
+		JSON().Object().NotContainsKey("new").
+		Value("old").Object().Value("lastSeen").Equal(200)
+
+	diff := expector.
+		PUT("/tables/onlines").
+		WithJSON(map[string]interface{}{"$set": set, "$inc": map[string]interface{}{"lastSeen": 1}, "$unset": map[string]interface{}{"updatedAt": ""}, "return": "diff"}).
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("diff").Object()
+	diff.Value("set").Object().Equal(map[string]interface{}{"lastSeen": 201})
+	diff.Value("unset").Array().Length().Equal(1)
+	diff.Value("unset").Array().Element(0).Equal("updatedAt")
+
+	expector.
+		PUT("/tables/onlines").
+		WithJSON(map[string]interface{}{"$set": set, "return": "everything"}).
+		Expect().Status(http.StatusUnprocessableEntity)
+}
+
+func TestDeleteWithReturn(t *testing.T) {
+	expector := getExpector(t)
+
+	expector.
+		DELETE("/tables/onlines").
+		WithQuery("hash", "1").
+		WithQuery("sort", "person1").
+		WithQuery("return", "none").
+		Expect().Status(http.StatusOK).
+		JSON().Object().Empty()
+
+	expector.
+		DELETE("/tables/onlines").
+		WithQuery("hash", "1").
+		WithQuery("sort", "person2").
+		WithQuery("return", "diff").
+		Expect().Status(http.StatusOK).
+		JSON().Object().NotContainsKey("set").
+		Value("unset").Array().Length().Equal(5)
+
+	expector.
+		DELETE("/tables/onlines").
+		WithQuery("hash", "1").
+		WithQuery("sort", "person3").
+		WithQuery("return", "everything").
+		Expect().Status(http.StatusUnprocessableEntity)
+
+	expector.
+		GET("/tables/onlines").
+		WithQuery("hash", "1").
+		WithQuery("sort", "person3").
+		Expect().Status(http.StatusOK)
+}
+
+func TestPutWithIdempotencyKey(t *testing.T) {
+	expector := getExpector(t)
+
+	put := map[string]interface{}{
+		"$set":         map[string]interface{}{"channelId": "1", "personKey": "person9", "expiresAt": 2600000000000},
+		"$setOnInsert": map[string]interface{}{"createdAt": 1},
+	}
+
+	first := expector.
+		PUT("/tables/onlines").
+		WithHeader("Idempotency-Key", "key1").
+		WithJSON(put).
+		Expect().Status(http.StatusOK)
+	first.JSON().Object().Value("replaced").Equal(false)
+	version := first.JSON().Object().Value("version").Raw()
+
+	replayed := expector.
+		PUT("/tables/onlines").
+		WithHeader("Idempotency-Key", "key1").
+		WithJSON(put).
+		Expect().Status(http.StatusOK)
+	replayed.Header("Idempotent-Replayed").Equal("true")
+	replayed.JSON().Object().Value("replaced").Equal(false)
+	replayed.JSON().Object().Value("version").Equal(version)
+
+	expector.
+		PUT("/tables/onlines").
+		WithHeader("Idempotency-Key", "key2").
+		WithJSON(put).
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("replaced").Equal(true)
+
+	put["$setOnInsert"] = map[string]interface{}{"createdAt": 2}
+	expector.
+		PUT("/tables/onlines").
+		WithHeader("Idempotency-Key", "key1").
+		WithJSON(put).
+		Expect().Status(http.StatusUnprocessableEntity).
+		JSON().Object().ValueEqual("error", bingodb.IdempotencyKeyReused)
+
+	invalid := map[string]interface{}{"$set": map[string]interface{}{"channelId": "1"}}
+	expector.
+		PUT("/tables/onlines").
+		WithHeader("Idempotency-Key", "key3").
+		WithJSON(invalid).
+		Expect().Status(http.StatusUnprocessableEntity).
+		JSON().Array().Element(0).Object().ValueEqual("error", bingodb.SortKeyMissing)
+
+	expector.
+		PUT("/tables/onlines").
+		WithHeader("Idempotency-Key", "key3").
+		WithJSON(invalid).
+		Expect().Status(http.StatusUnprocessableEntity).
+		JSON().Array().Element(0).Object().ValueEqual("error", bingodb.SortKeyMissing)
+}
+
+func TestConditionFailureWithIdempotencyKey(t *testing.T) {
+	expector := getExpector(t)
+
+	put := map[string]interface{}{
+		"$set": map[string]interface{}{"channelId": "1", "personKey": "person9", "expiresAt": 2600000000000},
+		"$if":  map[string]interface{}{"$exists": false},
+	}
+	expector.
+		PUT("/tables/onlines").
+		WithJSON(map[string]interface{}{"$set": put["$set"]}).
+		Expect().Status(http.StatusOK)
+
+	expector.
+		PUT("/tables/onlines").
+		WithHeader("Idempotency-Key", "key1").
+		WithJSON(put).
+		Expect().Status(http.StatusConflict)
+
+	expector.
+		DELETE("/tables/onlines").
+		WithQuery("hash", "1").
+		WithQuery("sort", "person9").
+		Expect().Status(http.StatusOK)
+
+	retried := expector.
+		PUT("/tables/onlines").
+		WithHeader("Idempotency-Key", "key1").
+		WithJSON(put).
+		Expect().Status(http.StatusOK)
+	retried.Header("Idempotent-Replayed").Empty()
+	retried.JSON().Object().Value("replaced").Equal(false)
+}
+
+func TestIdempotencyKeyReleasedOnPanic(t *testing.T) {
+	bingo := bingodb.NewBingoFromConfigFile("../config/test.yml")
+	resource := &Resource{bingo: bingo}
+
+	panicked := false
+	engine := gin.New()
+	engine.Use(gin.Recovery())
+	engine.PUT("/panic", resource.Idempotent, func(ctx *gin.Context) {
+		if !panicked {
+			panicked = true
+			panic("handler failed")
+		}
+		ctx.JSON(http.StatusOK, gin.H{})
+	})
+
+	expector := httpexpect.WithConfig(httpexpect.Config{
+		Reporter: httpexpect.NewAssertReporter(t),
+		Client:   &http.Client{Transport: httpexpect.NewBinder(engine)},
+	})
+
+	expector.
+		PUT("/panic").
+		WithHeader("Idempotency-Key", "key1").
+		Expect().Status(http.StatusInternalServerError)
+
+	expector.
+		PUT("/panic").
+		WithHeader("Idempotency-Key", "key1").
+		Expect().Status(http.StatusOK)
+}
+
+func TestDeleteWithIdempotencyKey(t *testing.T) {
+	expector := getExpector(t)
+
+	expector.
+		DELETE("/tables/onlines").
+		WithHeader("Idempotency-Key", "key1").
+		WithQuery("hash", "1").
+		Expect().Status(http.StatusUnprocessableEntity)
+
+	expector.
+		DELETE("/tables/onlines").
+		WithHeader("Idempotency-Key", "key1").
+		WithQuery("hash", "1").
+		WithQuery("sort", "person1").
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("personKey").Equal("person1")
+
+	expector.
+		DELETE("/tables/onlines").
+		WithHeader("Idempotency-Key", "key1").
+		WithQuery("hash", "1").
+		WithQuery("sort", "person1").
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("personKey").Equal("person1")
+
+	expector.
+		DELETE("/tables/onlines").
+		WithQuery("hash", "1").
+		WithQuery("sort", "person1").
+		Expect().Status(http.StatusUnprocessableEntity)
+}
+
+func TestScanWithFilter(t *testing.T) {
+	expector := getExpector(t)
+
+	values := expector.
+		GET("/tables/onlines/scan").
+		WithQuery("hash", "1").
+		WithQuery("filter", "lastSeen > 125 && updatedAt < 1600000000000 || lastSeen == 123").
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("values").Array()
+	values.Length().Equal(2)
+	values.Element(0).Object().Value("personKey").Equal("person1")
+	values.Element(1).Object().Value("personKey").Equal("person3")
+
+	result := expector.
+		GET("/tables/onlines/indices/guest/scan").
+		WithQuery("hash", "1").
+		WithQuery("filter", `personKey != "person2"`).
+		WithQuery("limit", "1").
+		Expect().Status(http.StatusOK).
+		JSON().Object()
+	result.Value("values").Array().Element(0).Object().Value("personKey").Equal("person3")
+	followNext(expector, "/tables/onlines/indices/guest/scan", result).
+		Value("values").Array().Element(0).Object().Value("personKey").Equal("person1")
+
+	result = expector.
+		GET("/tables/onlines/scan").
+		WithQuery("hash", "1").
+		WithQuery("filter", `personKey == "person3"`).
+		WithQuery("maxExamined", "2").
+		Expect().Status(http.StatusOK).
+		JSON().Object()
+	result.Value("values").Array().Length().Equal(0)
+	followNext(expector, "/tables/onlines/scan", result).
+		Value("values").Array().Element(0).Object().Value("personKey").Equal("person3")
+
+	expector.
+		GET("/tables/onlines/scan").
+		WithQuery("hash", "1").
+		WithQuery("filter", "lastSeen >").
+		Expect().Status(http.StatusUnprocessableEntity)
+}
+
+func TestGetAndScanWithFields(t *testing.T) {
+	expector := getExpector(t)
+
+	expector.
+		GET("/tables/onlines").
+		WithQuery("hash", "1").
+		WithQuery("sort", "person1").
+		WithQuery("fields", "personKey,lastSeen,unknown").
+		Expect().Status(http.StatusOK).
+		JSON().Object().Equal(map[string]interface{}{"personKey": "person1", "lastSeen": 123, "_version": 1})
+
+	values := expector.
+		GET("/tables/onlines/indices/guest/scan").
+		WithQuery("hash", "1").
+		WithQuery("fields", "personKey").
+		WithQuery("fields", "updatedAt").
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("values").Array()
+	values.Length().Equal(3)
+	values.Element(0).Object().Equal(map[string]interface{}{"personKey": "person3", "updatedAt": 1500000000000})
+
+	expector.
+		POST("/tables/onlines/get").
+		WithQuery("fields", "lastSeen").
+		WithJSON([]map[string]interface{}{{"hash": "1", "sort": "person2"}}).
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("values").Array().Element(0).Object().Equal(map[string]interface{}{"lastSeen": 129})
+}
+
+func TestScanWithUntil(t *testing.T) {
+	expector := getExpector(t)
+
+	expector.
+		GET("/tables/onlines/scan").
+		WithQuery("hash", "1").
+		WithQuery("since", "person1").
+		WithQuery("until", "person3").
+		WithQuery("sinceExclusive", "true").
+		WithQuery("untilExclusive", "true").
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("values").Array().
+		Element(0).Object().Value("personKey").Equal("person2")
+
+	result := expector.
+		GET("/tables/onlines/scan").
+		WithQuery("hash", "1").
+		WithQuery("until", "person2").
+		WithQuery("limit", "1").
+		Expect().Status(http.StatusOK).
+		JSON().Object()
+	result.Value("values").Array().Length().Equal(1)
+	result = followNext(expector, "/tables/onlines/scan", result)
+	result.Value("values").Array().Length().Equal(1)
+	result.Value("values").Array().Element(0).Object().Value("personKey").Equal("person2")
+
+	expector.
+		GET("/tables/onlines/scan").
+		WithQuery("hash", "1").
+		WithQuery("until", "person2").
+		WithQuery("limit", "2").
+		Expect().Status(http.StatusOK).
+		JSON().Object().NotContainsKey("next").
+		Value("values").Array().Length().Equal(2)
+
+	values := expector.
+		GET("/tables/onlines/scan").
+		WithQuery("hash", "1").
+		WithQuery("since", "person3").
+		WithQuery("until", "person1").
+		WithQuery("untilExclusive", "true").
+		WithQuery("backward", "true").
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("values").Array()
+	values.Length().Equal(2)
+	values.Element(0).Object().Value("personKey").Equal("person3")
+	values.Element(1).Object().Value("personKey").Equal("person2")
+
+	values = expector.
+		GET("/tables/onlines/indices/guest/scan").
+		WithQuery("hash", "1").
+		WithQuery("since", "1500000000000").
+		WithQuery("until", "1700000000000").
+		WithQuery("sinceExclusive", "true").
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("values").Array()
+	values.Length().Equal(2)
+	values.Element(0).Object().Value("personKey").Equal("person2")
+	values.Element(1).Object().Value("personKey").Equal("person1")
+
+	expector.
+		GET("/tables/onlines/indices/guest/scan").
+		WithQuery("hash", "1").
+		WithQuery("until", "1600000000000").
+		WithQuery("untilExclusive", "true").
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("values").Array().Length().Equal(1)
+
+	expector.
+		DELETE("/tables/onlines/range").
+		WithQuery("hash", "1").
+		WithQuery("since", "person1").
+		WithQuery("sinceExclusive", "true").
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("count").Equal(2)
+}
+
+func TestCount(t *testing.T) {
+	expector := getExpector(t)
+
+	expector.
+		GET("/tables/onlines/count").
+		WithQuery("hash", "1").
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("count").Equal(3)
+
+	expector.
+		GET("/tables/onlines/count").
+		WithQuery("hash", "1").
+		WithQuery("since", "person2").
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("count").Equal(2)
+
+	expector.
+		GET("/tables/onlines/indices/guest/count").
+		WithQuery("hash", "1").
+		WithQuery("until", "1600000000000").
+		WithQuery("untilExclusive", "true").
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("count").Equal(1)
+
+	expector.
+		GET("/tables/onlines/indices/guest/count").
+		WithQuery("hash", "1").
+		WithQuery("filter", "lastSeen >= 129").
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("count").Equal(2)
+
+	expector.
+		GET("/tables/onlines/count").
+		WithQuery("hash", "2").
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("count").Equal(0)
+
+	expector.
+		GET("/tables/onlines/count").
+		Expect().Status(http.StatusUnprocessableEntity)
+}
+
+func TestAggregate(t *testing.T) {
+	expector := getExpector(t)
+
+	for op, expected := range map[string]interface{}{"sum": 384, "min": 123, "max": 132, "avg": 128} {
+		result := expector.
+			GET("/tables/onlines/aggregate").
+			WithQuery("hash", "1").
+			WithQuery("op", op).
+			WithQuery("field", "lastSeen").
+			Expect().Status(http.StatusOK).
+			JSON().Object()
+		result.Value("value").Equal(expected)
+		result.Value("count").Equal(3)
+	}
+
+	expector.
+		GET("/tables/onlines/indices/guest/aggregate").
+		WithQuery("hash", "1").
+		WithQuery("since", "1600000000000").
+		WithQuery("op", "sum").
+		WithQuery("field", "lastSeen").
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("value").Equal(252)
+
+	for key, team := range map[string]string{"person1": "a", "person2": "b", "person4": "a"} {
+		expector.
+			PUT("/tables/onlines").
+			WithJSON(map[string]interface{}{
+				"$set":         map[string]interface{}{"channelId": "1", "personKey": key, "team": team, "expiresAt": 2600000000000},
+				"$setOnInsert": map[string]interface{}{"lastSeen": 1},
+			}).
+			Expect().Status(http.StatusOK)
+	}
+
+	groups := expector.
+		GET("/tables/onlines/aggregate").
+		WithQuery("hash", "1").
+		WithQuery("op", "max").
+		WithQuery("field", "lastSeen").
+		WithQuery("groupBy", "team").
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("groups").Array()
+	groups.Length().Equal(3)
+	groups.Element(0).Object().NotContainsKey("group").Value("value").Equal(132)
+	groups.Element(1).Object().Equal(map[string]interface{}{"group": "a", "value": 123, "count": 2})
+	groups.Element(2).Object().Equal(map[string]interface{}{"group": "b", "value": 129, "count": 1})
+
+	expector.
+		GET("/tables/onlines/aggregate").
+		WithQuery("hash", "1").
+		WithQuery("op", "median").
+		WithQuery("field", "lastSeen").
+		Expect().Status(http.StatusUnprocessableEntity)
+
+	expector.
+		GET("/tables/onlines/aggregate").
+		WithQuery("hash", "1").
+		WithQuery("op", "sum").
+		Expect().Status(http.StatusUnprocessableEntity)
+}
+
+func TestPartitions(t *testing.T) {
+	expector := getExpector(t)
+
+	for _, channel := range []string{"3", "2", "4"} {
+		expector.
+			PUT("/tables/onlines").
+			WithJSON(map[string]interface{}{
+				"$set": map[string]interface{}{"channelId": channel, "personKey": "person1", "updatedAt": 1, "expiresAt": 2600000000000},
+			}).
+			Expect().Status(http.StatusOK)
+	}
+	expector.
+		DELETE("/tables/onlines").
+		WithQuery("hash", "4").
+		WithQuery("sort", "person1").
+		Expect().Status(http.StatusOK)
+
+	result := expector.
+		GET("/tables/onlines/partitions").
+		WithQuery("limit", "2").
+		Expect().Status(http.StatusOK).
+		JSON().Object()
+	result.Value("values").Array().Element(0).Object().Equal(map[string]interface{}{"hash": "1", "size": 3})
+	result.Value("values").Array().Element(1).Object().Equal(map[string]interface{}{"hash": "2", "size": 1})
+	result.Value("next").Equal("2")
+
+	result = expector.
+		GET("/tables/onlines/partitions").
+		WithQuery("limit", "2").
+		WithQuery("after", "2").
+		Expect().Status(http.StatusOK).
+		JSON().Object()
+	result.Value("values").Array().Length().Equal(1)
+	result.Value("values").Array().Element(0).Object().Value("hash").Equal("3")
+	result.NotContainsKey("next")
+
+	expector.
+		GET("/tables/onlines/indices/guest/partitions").
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("values").Array().Length().Equal(3)
+
+	expector.
+		GET("/tables/onlines/indices/unknown/partitions").
+		Expect().Status(http.StatusUnprocessableEntity)
+
+	for _, limit := range []string{"0", "-1", "dummy"} {
+		expector.
+			GET("/tables/onlines/partitions").
+			WithQuery("limit", limit).
+			Expect().Status(http.StatusUnprocessableEntity).
+			JSON().Array().Element(0).Object().ValueEqual("error", InvalidLimit)
+	}
+}
+
+func TestAll(t *testing.T) {
+	expector := getExpector(t)
+
+	expector.
+		PUT("/tables/onlines").
+		WithJSON(map[string]interface{}{
+			"$set": map[string]interface{}{"channelId": "0", "personKey": "person9", "updatedAt": 1, "expiresAt": 2600000000000},
+		}).
+		Expect().Status(http.StatusOK)
+
+	result := expector.
+		GET("/tables/onlines/all").
+		WithQuery("limit", "2").
+		Expect().Status(http.StatusOK).
+		JSON().Object()
+	result.Value("values").Array().Length().Equal(2)
+	result.Value("values").Array().Element(0).Object().Value("channelId").Equal("0")
+	result.Value("values").Array().Element(1).Object().Value("personKey").Equal("person1")
+
+	result = expector.
+		GET("/tables/onlines/all").
+		WithQuery("limit", "2").
+		WithQuery("cursor", result.Value("next").Raw()).
+		Expect().Status(http.StatusOK).
+		JSON().Object()
+	result.Value("values").Array().Length().Equal(2)
+	result.Value("values").Array().Element(0).Object().Value("personKey").Equal("person2")
+	result.Value("values").Array().Element(1).Object().Value("personKey").Equal("person3")
+	result.NotContainsKey("next")
+
+	expector.
+		GET("/tables/unknown/all").
+		Expect().Status(http.StatusUnprocessableEntity)
+
+	for _, limit := range []string{"0", "-1", "dummy"} {
+		expector.
+			GET("/tables/onlines/all").
+			WithQuery("limit", limit).
+			Expect().Status(http.StatusUnprocessableEntity).
+			JSON().Array().Element(0).Object().ValueEqual("error", InvalidLimit)
+	}
+}
+
+func TestScanWithPrefix(t *testing.T) {
+	expector := getExpector(t)
+
+	expector.
+		GET("/tables/tests/scan").
+		WithQuery("hash", "0").
+		WithQuery("prefix", "1").
+		Expect().Status(http.StatusUnprocessableEntity).
+		JSON().Array().Element(0).Object().ValueEqual("error", bingodb.PrefixNotString)
+
+	for _, key := range []string{"guest1", "person10", "persona"} {
+		expector.
+			PUT("/tables/onlines").
+			WithJSON(map[string]interface{}{
+				"$set": map[string]interface{}{"channelId": "1", "personKey": key, "updatedAt": 1, "expiresAt": 2600000000000},
+			}).
+			Expect().Status(http.StatusOK)
+	}
+
+	result := expector.
+		GET("/tables/onlines/scan").
+		WithQuery("hash", "1").
+		WithQuery("prefix", "person1").
+		Expect().Status(http.StatusOK).
+		JSON().Object()
+	result.Value("values").Array().Length().Equal(2)
+	result.Value("values").Array().Element(1).Object().Value("personKey").Equal("person10")
+
+	expector.
+		GET("/tables/onlines/count").
+		WithQuery("hash", "1").
+		WithQuery("prefix", "person").
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("count").Equal(5)
+}
+
+func TestMergedScan(t *testing.T) {
+	expector := getExpector(t)
+
+	for key, updatedAt := range map[string]int64{"person4": 1550000000000, "person5": 1800000000000} {
+		expector.
+			PUT("/tables/onlines").
+			WithJSON(map[string]interface{}{
+				"$set": map[string]interface{}{"channelId": "2", "personKey": key, "updatedAt": updatedAt, "expiresAt": 2600000000000},
+			}).
+			Expect().Status(http.StatusOK)
+	}
+
+	obj := expector.
+		GET("/tables/onlines/indices/guest/scan").
+		WithQuery("hash", "1").
+		WithQuery("hash", "2").
+		WithQuery("limit", "2").
+		Expect().Status(http.StatusOK).
+		JSON().Object()
+	obj.Value("values").Array().Length().Equal(2)
+	obj.Value("values").Array().Element(0).Object().Value("personKey").Equal("person3")
+	obj.Value("values").Array().Element(1).Object().Value("personKey").Equal("person4")
+
+	obj = followNext(expector, "/tables/onlines/indices/guest/scan", obj)
+	obj.Value("values").Array().Length().Equal(3)
+	obj.Value("values").Array().Element(0).Object().Value("personKey").Equal("person2")
+	obj.Value("values").Array().Element(2).Object().Value("personKey").Equal("person5")
+	obj.NotContainsKey("next")
+
+	expector.
+		GET("/tables/onlines/indices/guest/scan").
+		WithQuery("cursor", "[").
+		Expect().Status(http.StatusUnprocessableEntity)
+}
+
+func TestScanCursor(t *testing.T) {
+	expector := getExpector(t)
+
+	obj := expector.
+		GET("/tables/onlines/scan").
+		WithQuery("hash", "1").
+		WithQuery("filter", "lastSeen > 100").
+		WithQuery("limit", "1").
+		Expect().Status(http.StatusOK).
+		JSON().Object()
+	next := obj.Value("next").Raw().(string)
+	if strings.Contains(next, "person") {
+		t.Errorf("next must be opaque. Got %v", next)
+	}
+
+	expector.
+		GET("/tables/onlines/scan").
+		WithQuery("cursor", next).
+		WithQuery("filter", "lastSeen > 100").
+		WithQuery("hash", "1").
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("values").Array().Length().Equal(2)
+
+	expector.
+		GET("/tables/onlines/scan").
+		WithQuery("cursor", next).
+		WithQuery("filter", "lastSeen > 200").
+		Expect().Status(http.StatusUnprocessableEntity).
+		JSON().Array().Element(0).Object().Value("error").Equal("filter differs from that of cursor")
+
+	expector.
+		GET("/tables/onlines/indices/guest/scan").
+		WithQuery("cursor", next).
+		Expect().Status(http.StatusUnprocessableEntity).
+		JSON().Array().Element(0).Object().Value("error").Equal(InvalidCursor)
+
+	next = expector.
+		GET("/tables/onlines/scan").
+		WithQuery("hash", "1").
+		WithQuery("until", "person3").
+		WithQuery("untilExclusive", "true").
+		WithQuery("limit", "1").
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("next").Raw().(string)
+
+	expector.
+		GET("/tables/onlines/scan").
+		WithQuery("cursor", next).
+		WithQuery("until", "person3").
+		WithQuery("untilExclusive", "true").
+		WithQuery("sinceExclusive", "true").
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("values").Array().Length().Equal(1)
+
+	for name, value := range map[string]string{"until": "person9", "untilExclusive": "false"} {
+		expector.
+			GET("/tables/onlines/scan").
+			WithQuery("cursor", next).
+			WithQuery(name, value).
+			Expect().Status(http.StatusUnprocessableEntity).
+			JSON().Array().Element(0).Object().Value("error").Equal(name + " differs from that of cursor")
+	}
+
+	expector.
+		GET("/tables/onlines/all").
+		WithQuery("cursor", next).
+		Expect().Status(http.StatusUnprocessableEntity)
+
+	expector.
+		GET("/tables/onlines/scan").
+		WithQuery("cursor", (&Cursor{Version: CursorVersion + 1, Kind: scanCursor, Table: "onlines"}).Token([]byte("test"))).
+		Expect().Status(http.StatusUnprocessableEntity)
+
+	forged := &Cursor{Version: CursorVersion, Kind: scanCursor, Table: "onlines", Hash: "1"}
+	expector.
+		GET("/tables/onlines/scan").
+		WithQuery("cursor", forged.Token([]byte("other"))).
+		Expect().Status(http.StatusUnprocessableEntity).
+		JSON().Array().Element(0).Object().Value("error").Equal(InvalidCursor)
+
+	token := forged.Token([]byte("test"))
+	expector.
+		GET("/tables/onlines/scan").
+		WithQuery("cursor", token).
+		Expect().Status(http.StatusOK)
+
+	data, _ := json.Marshal(&Cursor{Version: CursorVersion, Kind: scanCursor, Table: "onlines", Hash: "2"})
+	tampered := base64.RawURLEncoding.EncodeToString(data) + token[strings.LastIndex(token, "."):]
+	for _, cursor := range []string{tampered, base64.RawURLEncoding.EncodeToString(data)} {
+		expector.
+			GET("/tables/onlines/scan").
+			WithQuery("cursor", cursor).
+			Expect().Status(http.StatusUnprocessableEntity).
+			JSON().Array().Element(0).Object().Value("error").Equal(InvalidCursor)
+	}
+}
+
+func TestSnapshotScan(t *testing.T) {
+	expector := getExpector(t)
+
+	obj := expector.
+		GET("/tables/onlines/indices/guest/scan").
+		WithQuery("hash", "1").
+		WithQuery("limit", "1").
+		WithQuery("snapshot", "true").
+		Expect().Status(http.StatusOK).
+		JSON().Object()
+	obj.Value("values").Array().Element(0).Object().Value("personKey").Equal("person3")
+
+	// person2 moves to the front and person1 is removed after the first page
+	expector.
+		PUT("/tables/onlines").
+		WithJSON(map[string]interface{}{
+			"$set": map[string]interface{}{"channelId": "1", "personKey": "person2", "updatedAt": 1000000000000, "expiresAt": 2600000000000},
+		}).
+		Expect().Status(http.StatusOK)
+	expector.
+		DELETE("/tables/onlines").
+		WithQuery("hash", "1").
+		WithQuery("sort", "person1").
+		Expect().Status(http.StatusOK)
+
+	obj = followNext(expector, "/tables/onlines/indices/guest/scan", obj)
+	obj.Value("values").Array().Length().Equal(2)
+	obj.Value("values").Array().Element(0).Object().Value("updatedAt").Equal(1600000000000)
+	obj.Value("values").Array().Element(1).Object().Value("personKey").Equal("person1")
+
+	expector.
+		GET("/tables/onlines/indices/guest/scan").
+		WithQuery("hash", "1").
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("values").Array().Length().Equal(2)
+
+	expector.
+		GET("/tables/tests/scan").
+		WithQuery("hash", "1").
+		WithQuery("snapshot", "true").
+		Expect().Status(http.StatusUnprocessableEntity)
+
+	expector.
+		GET("/tables/onlines/scan").
+		WithQuery("hash", "1").
+		WithQuery("hash", "2").
+		WithQuery("snapshot", "true").
+		Expect().Status(http.StatusUnprocessableEntity)
+}
+
+func TestRank(t *testing.T) {
+	expector := getExpector(t)
+
+	expector.
+		GET("/tables/onlines/rank").
+		WithQuery("hash", "1").
+		WithQuery("sort", "person2").
+		Expect().Status(http.StatusOK).
+		JSON().Object().Equal(map[string]interface{}{"rank": 1, "size": 3})
+
+	expector.
+		GET("/tables/onlines/indices/guest/rank").
+		WithQuery("hash", "1").
+		WithQuery("sort", "1700000000000").
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("rank").Equal(2)
+
+	expector.
+		GET("/tables/onlines/rank").
+		WithQuery("hash", "1").
+		WithQuery("sort", "person9").
+		Expect().Status(http.StatusUnprocessableEntity)
+
+	expector.
+		GET("/tables/onlines/indices/guest/scan").
+		WithQuery("hash", "1").
+		WithQuery("offset", "1").
+		WithQuery("limit", "1").
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("values").Array().Element(0).Object().Value("personKey").Equal("person2")
+
+	next := expector.
+		GET("/tables/onlines/indices/guest/scan").
+		WithQuery("hash", "1").
+		WithQuery("offset", "1").
+		WithQuery("limit", "1").
+		Expect().Status(http.StatusOK).
+		JSON().Object().Value("next").String().Raw()
+
+	for _, offset := range []string{"", "1"} {
+		request := expector.
+			GET("/tables/onlines/indices/guest/scan").
+			WithQuery("cursor", next).
+			WithQuery("limit", "1")
+		if offset != "" {
+			request = request.WithQuery("offset", offset)
+		}
+		page := request.Expect().Status(http.StatusOK).JSON().Object()
+		page.Value("values").Array().Length().Equal(1)
+		page.Value("values").Array().Element(0).Object().Value("personKey").Equal("person1")
+		page.NotContainsKey("next")
+	}
+}
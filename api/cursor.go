@@ -0,0 +1,213 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/zoyi/bingodb"
+	"strconv"
+	"strings"
+)
+
+// CursorVersion is the version of cursors issued, older ones are rejected.
+const CursorVersion = 1
+
+const (
+	scanCursor   = "scan"
+	mergedCursor = "merged"
+	allCursor    = "all"
+)
+
+// Cursor is where a scan continues from with the parameters it ran with.
+// Clients get it as an opaque `next` token and hand it back as `cursor`.
+type Cursor struct {
+	Version        int                     `json:"v"`
+	Kind           string                  `json:"k"`
+	Table          string                  `json:"t"`
+	Index          string                  `json:"i,omitempty"`
+	Hash           interface{}             `json:"h,omitempty"`
+	Since          []interface{}           `json:"s,omitempty"`
+	Partitions     []*PartitionCursorQuery `json:"p,omitempty"`
+	Until          []interface{}           `json:"u,omitempty"`
+	UntilExclusive bool                    `json:"ue,omitempty"`
+	Prefix         string                  `json:"x,omitempty"`
+	Filter         string                  `json:"f,omitempty"`
+	Backward       bool                    `json:"b,omitempty"`
+	// Snapshot is the version of the table a snapshot scan reads as of
+	Snapshot *int64 `json:"ss,omitempty"`
+}
+
+// newCursorKey returns the key signing cursors, the secret of config or a
+// random one if not specified, with which cursors last until a restart.
+func newCursorKey(config *bingodb.ServerConfig) []byte {
+	if config != nil && len(config.CursorSecret) > 0 {
+		return []byte(config.CursorSecret)
+	}
+	key := make([]byte, 32)
+	rand.Read(key)
+	return key
+}
+
+// newCursor starts a cursor of the scan requested by ctx and query.
+func newCursor(ctx *gin.Context, kind string, query *ScanQuery) *Cursor {
+	cursor := &Cursor{
+		Version:        CursorVersion,
+		Kind:           kind,
+		Table:          ctx.Param("table"),
+		Index:          ctx.Param("index"),
+		Hash:           query.HashKey,
+		UntilExclusive: query.UntilExclusive,
+		Prefix:         query.Prefix,
+		Filter:         query.Filter,
+		Backward:       query.Backward,
+	}
+	if len(query.Until) > 0 && query.Until[0] != nil {
+		cursor.Until = query.Until
+	}
+	return cursor
+}
+
+// Token encodes the cursor to hand to clients, signed with key so that
+// clients cannot forge or alter it.
+func (cursor *Cursor) Token(key []byte) string {
+	data, _ := json.Marshal(cursor)
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(signCursor(payload, key))
+}
+
+func signCursor(payload string, key []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// decodeCursor decodes a token issued with key, failing if it was not.
+func decodeCursor(token string, key []byte) (*Cursor, error) {
+	separator := strings.LastIndex(token, ".")
+	if separator < 0 {
+		return nil, errors.New(InvalidCursor)
+	}
+	payload := token[:separator]
+	signature, err := base64.RawURLEncoding.DecodeString(token[separator+1:])
+	if err != nil || !hmac.Equal(signature, signCursor(payload, key)) {
+		return nil, errors.New(InvalidCursor)
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, errors.New(InvalidCursor)
+	}
+
+	cursor := &Cursor{}
+	decoder := json.NewDecoder(strings.NewReader(string(data)))
+	decoder.UseNumber()
+	if err := decoder.Decode(cursor); err != nil || cursor.Version != CursorVersion {
+		return nil, errors.New(InvalidCursor)
+	}
+	return cursor, nil
+}
+
+// fetchCursor reads `cursor` of a scan of kind, nil if not given. It fails
+// when the cursor was issued for another scan, or when parameters given
+// along with it differ from those it was issued with. Since, sinceExclusive
+// and offset only position the first page and are ignored.
+func (rs *Resource) fetchCursor(ctx *gin.Context, kinds ...string) (*Cursor, error) {
+	token, ok := ctx.GetQuery("cursor")
+	if !ok {
+		return nil, nil
+	}
+
+	cursor, err := decodeCursor(token, rs.cursorKey)
+	if err != nil {
+		return nil, err
+	}
+
+	matched := false
+	for _, kind := range kinds {
+		matched = matched || cursor.Kind == kind
+	}
+	if !matched || cursor.Table != ctx.Param("table") || cursor.Index != ctx.Param("index") {
+		return nil, errors.New(InvalidCursor)
+	}
+
+	if value, ok := ctx.GetQuery("hash"); ok && cursor.Kind == scanCursor && value != fmt.Sprint(cursor.Hash) {
+		return nil, fmt.Errorf(CursorMismatch, "hash")
+	}
+	if value, ok := ctx.GetQuery("backward"); ok {
+		if backward, _ := strconv.ParseBool(value); backward != cursor.Backward {
+			return nil, fmt.Errorf(CursorMismatch, "backward")
+		}
+	}
+	if value, ok := ctx.GetQuery("filter"); ok && value != cursor.Filter {
+		return nil, fmt.Errorf(CursorMismatch, "filter")
+	}
+	if value, ok := ctx.GetQuery("prefix"); ok && value != cursor.Prefix {
+		return nil, fmt.Errorf(CursorMismatch, "prefix")
+	}
+	if values, ok := ctx.GetQueryArray("until"); ok && !sameSortKey(values, cursor.Until) {
+		return nil, fmt.Errorf(CursorMismatch, "until")
+	}
+	if value, ok := ctx.GetQuery("untilExclusive"); ok {
+		if exclusive, _ := strconv.ParseBool(value); exclusive != cursor.UntilExclusive {
+			return nil, fmt.Errorf(CursorMismatch, "untilExclusive")
+		}
+	}
+	if value, ok := ctx.GetQuery("snapshot"); ok {
+		if snapshot, _ := strconv.ParseBool(value); snapshot != (cursor.Snapshot != nil) {
+			return nil, fmt.Errorf(CursorMismatch, "snapshot")
+		}
+	}
+	return cursor, nil
+}
+
+// sameSortKey reports whether values given as a sort key, like those read
+// by fetchSortKey, make the sort key of a cursor.
+func sameSortKey(values []string, key []interface{}) bool {
+	for i := 0; i < 3; i++ {
+		var value, given interface{}
+		if i < len(key) {
+			value = key[i]
+		}
+		if i < len(values) {
+			given = values[i]
+		}
+		if (value == nil) != (given == nil) || (value != nil && fmt.Sprint(value) != given) {
+			return false
+		}
+	}
+	return true
+}
+
+// restore continues query from the cursor, in place of its `since`.
+// Keys are kept as tuples of three like those read by fetchSortKey. The
+// offset was skipped by the first page, so it is not applied again.
+func (cursor *Cursor) restore(query *ScanQuery) {
+	query.HashKey = cursor.Hash
+	query.Offset = 0
+	query.Since = make([]interface{}, 3)
+	copy(query.Since, cursor.Since)
+	query.SinceExclusive = false
+	query.Until = make([]interface{}, 3)
+	copy(query.Until, cursor.Until)
+	query.UntilExclusive = cursor.UntilExclusive
+	query.Prefix = cursor.Prefix
+	query.Filter = cursor.Filter
+	query.Backward = cursor.Backward
+	query.Snapshot = cursor.Snapshot != nil
+}
+
+// sortKeyTuple makes a sort key of any index a tuple like `since` of scan.
+func sortKeyTuple(key interface{}) []interface{} {
+	switch key.(type) {
+	case bingodb.SubSortKey:
+		return key.(bingodb.SubSortKey).Array().([]interface{})
+	case []interface{}:
+		return key.([]interface{})
+	}
+	return []interface{}{key}
+}
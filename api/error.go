@@ -1,6 +1,14 @@
 package api
 
+import "github.com/zoyi/bingodb"
+
 const (
-	IndexNotFound = "index not found"
-	TableNotFound = "table not found"
+	IndexNotFound      = bingodb.IndexNotFound
+	TableNotFound      = "table not found"
+	PutOrDeleteMissing = "either put or delete is required"
+	UnknownReturn      = "unknown return '%s'"
+	InvalidCursor      = "invalid cursor"
+	CursorMismatch     = "%s differs from that of cursor"
+	SnapshotNotMerged  = "snapshot cannot scan multiple partitions"
+	InvalidLimit       = "limit must be a positive integer"
 )
@@ -0,0 +1,88 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/zoyi/bingodb"
+	"io/ioutil"
+	"net/http"
+)
+
+const (
+	IdempotencyKeyHeader = "Idempotency-Key"
+	ReplayedHeader       = "Idempotent-Replayed"
+)
+
+type responseRecorder struct {
+	gin.ResponseWriter
+	body *bytes.Buffer
+}
+
+func (recorder *responseRecorder) Write(data []byte) (int, error) {
+	recorder.body.Write(data)
+	return recorder.ResponseWriter.Write(data)
+}
+
+func (recorder *responseRecorder) WriteString(s string) (int, error) {
+	recorder.body.WriteString(s)
+	return recorder.ResponseWriter.WriteString(s)
+}
+
+// Idempotent replays the response of the first request with the same
+// Idempotency-Key header, method and URI instead of running it again.
+// A request reusing a key with another body is rejected. Only successful
+// responses are remembered, so retries of failed requests run again.
+func (rs *Resource) Idempotent(ctx *gin.Context) {
+	header := ctx.GetHeader(IdempotencyKeyHeader)
+	if len(header) == 0 {
+		ctx.Next()
+		return
+	}
+	key := fmt.Sprintf("%s %s %s", ctx.Request.Method, ctx.Request.URL.RequestURI(), header)
+
+	body, err := ioutil.ReadAll(ctx.Request.Body)
+	if err != nil {
+		ctx.Error(err)
+		ctx.Abort()
+		return
+	}
+	ctx.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+	digest := sha256.Sum256(body)
+
+	response, err := rs.bingo.ReserveResponse(key, hex.EncodeToString(digest[:]))
+	if err != nil {
+		status := http.StatusConflict
+		if err.Error() == bingodb.IdempotencyKeyReused {
+			status = http.StatusUnprocessableEntity
+		}
+		ctx.JSON(status, &ErrorResult{Error: err.Error()})
+		ctx.Abort()
+		return
+	}
+	if response != nil {
+		ctx.Header(ReplayedHeader, "true")
+		ctx.Data(response.Status, "application/json; charset=utf-8", response.Body)
+		ctx.Abort()
+		return
+	}
+
+	// The key is released unless a response is saved, also when a handler panics
+	saved := false
+	defer func() {
+		if !saved {
+			rs.bingo.ReleaseResponse(key)
+		}
+	}()
+
+	recorder := &responseRecorder{ResponseWriter: ctx.Writer, body: &bytes.Buffer{}}
+	ctx.Writer = recorder
+	ctx.Next()
+
+	if status := recorder.Status(); len(ctx.Errors) == 0 && status >= http.StatusOK && status < http.StatusMultipleChoices {
+		rs.bingo.SaveResponse(key, &bingodb.Response{Status: recorder.Status(), Body: recorder.body.Bytes()})
+		saved = true
+	}
+}
@@ -8,22 +8,131 @@ import (
 	"github.com/zoyi/bingodb"
 	"net/http"
 	"strconv"
+	"strings"
 )
 
 type PutQuery struct {
 	Set         bingodb.Data `json:"$set"`
 	SetOnInsert bingodb.Data `json:"$setOnInsert,omitempty"` //optional
+	Inc         bingodb.Data `json:"$inc,omitempty"`         //optional
+	Unset       bingodb.Data `json:"$unset,omitempty"`       //optional
+	Min         bingodb.Data `json:"$min,omitempty"`         //optional
+	Max         bingodb.Data `json:"$max,omitempty"`         //optional
+	Push        bingodb.Data `json:"$push,omitempty"`        //optional
+	AddToSet    bingodb.Data `json:"$addToSet,omitempty"`    //optional
+	Pull        bingodb.Data `json:"$pull,omitempty"`        //optional
+	If          bingodb.Data `json:"$if,omitempty"`          //optional
+	// ExpectedVersion is a shorthand of {"$if": {"$version": expectedVersion}}
+	ExpectedVersion *int64 `json:"expectedVersion,omitempty"` //optional
+	// Mode is "insert" to never replace or "update" to never create a document
+	Mode string `json:"mode,omitempty"` //optional
+	// Return is one of "none", "old", "new" and "diff", both old and new if empty
+	Return string `json:"return,omitempty"` //optional
 }
 
+func (query *PutQuery) Update() *bingodb.Update {
+	if query.ExpectedVersion != nil {
+		if query.If == nil {
+			query.If = make(bingodb.Data)
+		}
+		query.If["$version"] = *query.ExpectedVersion
+	}
+
+	return &bingodb.Update{
+		Set:         &query.Set,
+		SetOnInsert: &query.SetOnInsert,
+		Inc:         &query.Inc,
+		Unset:       &query.Unset,
+		Min:         &query.Min,
+		Max:         &query.Max,
+		Push:        &query.Push,
+		AddToSet:    &query.AddToSet,
+		Pull:        &query.Pull,
+		If:          &query.If,
+		Mode:        query.Mode,
+	}
+}
+
+type DeleteQuery struct {
+	Hash            interface{}  `json:"hash"`
+	Sort            interface{}  `json:"sort"`
+	If              bingodb.Data `json:"$if,omitempty"`             //optional
+	ExpectedVersion *int64       `json:"expectedVersion,omitempty"` //optional
+	Return          string       `json:"return,omitempty"`          //optional
+}
+
+func (query *DeleteQuery) Condition() *bingodb.Data {
+	if query.ExpectedVersion != nil {
+		if query.If == nil {
+			query.If = make(bingodb.Data)
+		}
+		query.If["$version"] = *query.ExpectedVersion
+	}
+	return &query.If
+}
+
+// KeyQuery is a key to look up. For sub indices sort may be an array of
+// sub sort key, primary hash key and primary sort key like `since` of scan.
+type KeyQuery struct {
+	Hash interface{} `json:"hash"`
+	Sort interface{} `json:"sort"`
+}
+
+// WriteQuery is an item of a batch, either a put or a delete.
+// Table is required only for the batch across tables.
+type WriteQuery struct {
+	Table  string       `json:"table,omitempty"`
+	Put    *PutQuery    `json:"put,omitempty"`
+	Delete *DeleteQuery `json:"delete,omitempty"`
+}
+
+func (query *WriteQuery) Return() string {
+	if query.Put != nil {
+		return query.Put.Return
+	} else if query.Delete != nil {
+		return query.Delete.Return
+	}
+	return ""
+}
+
+// VersionField is the field of a Get response holding the document version.
+const VersionField = "_version"
+
+const (
+	ReturnNone = "none"
+	ReturnOld  = "old"
+	ReturnNew  = "new"
+	ReturnDiff = "diff"
+)
+
 type ScanQuery struct {
-	HashKey  interface{}
-	Since    []interface{}
-	Limit    int
-	Backward bool
+	HashKey interface{}
+	Since   []interface{}
+	Until   []interface{}
+	Prefix  string
+	Filter  string
+	// SinceExclusive and UntilExclusive leave out the bounds themselves
+	SinceExclusive bool
+	UntilExclusive bool
+	Limit          int
+	Offset         int
+	Backward       bool
+	// MaxExamined caps documents examined by a filtered scan
+	MaxExamined int
+	// Snapshot scans the table as of the first page through every page
+	Snapshot bool
+}
+
+// PartitionCursorQuery is where a partition of a merged scan continues from.
+type PartitionCursorQuery struct {
+	Hash  interface{} `json:"hash"`
+	Since interface{} `json:"since"`
 }
 
 type Resource struct {
 	bingo *bingodb.Bingo
+	// cursorKey signs the cursors of scans
+	cursorKey []byte
 }
 
 type Overview struct {
@@ -38,30 +147,136 @@ type ScanResult struct {
 }
 
 type PutResult struct {
-	Old      interface{} `json:"old,omitempty"`
-	New      interface{} `json:"new"`
-	Replaced bool        `json:"replaced"`
+	Old      interface{}      `json:"old,omitempty"`
+	New      interface{}      `json:"new,omitempty"`
+	Diff     *bingodb.Changes `json:"diff,omitempty"`
+	Replaced bool             `json:"replaced"`
+	Version  int64            `json:"version"`
 }
 
-func newPutResult(old *bingodb.Document, newbie *bingodb.Document, replaced bool) *PutResult {
-	var oldDoc, newbieDoc bingodb.Data
-	if old != nil {
-		oldDoc = old.Data()
-	}
+type ConflictResult struct {
+	Error   string      `json:"error"`
+	Current interface{} `json:"current"`
+}
+
+type PartitionResult struct {
+	Hash interface{} `json:"hash"`
+	Size int64       `json:"size"`
+}
+
+type RankResult struct {
+	Rank int64 `json:"rank"`
+	Size int64 `json:"size"`
+}
+
+type CountResult struct {
+	Count int64 `json:"count"`
+}
+
+type AggregateResult struct {
+	Group interface{} `json:"group,omitempty"`
+	Value interface{} `json:"value"`
+	Count int64       `json:"count"`
+}
+
+type AggregateGroupsResult struct {
+	Groups []*AggregateResult `json:"groups"`
+}
+
+type ErrorResult struct {
+	Error string `json:"error"`
+}
+
+// BatchItemResult holds the status and the body a single PUT or DELETE
+// would have responded with.
+type BatchItemResult struct {
+	Status int         `json:"status"`
+	Body   interface{} `json:"body"`
+}
+
+type BatchResult struct {
+	Results []*BatchItemResult `json:"results"`
+}
+
+type TransactionErrorResult struct {
+	Error   string      `json:"error"`
+	Index   int         `json:"index"`
+	Current interface{} `json:"current,omitempty"`
+}
+
+func newPutResult(old *bingodb.Document, newbie *bingodb.Document, replaced bool, ret string) *PutResult {
+	result := &PutResult{Replaced: replaced}
 	if newbie != nil {
-		newbieDoc = newbie.Data()
+		result.Version = newbie.Version()
 	}
-	return &PutResult{Old: oldDoc, New: newbieDoc, Replaced: replaced}
-}
 
-func newListResponse(values []bingodb.Data, next interface{}) *ScanResult {
-	if next != nil {
-		switch next.(type) {
-		case bingodb.SubSortKey:
-			next = next.(bingodb.SubSortKey).Array()
+	switch ret {
+	case ReturnNone:
+	case ReturnOld:
+		if old != nil {
+			result.Old = old.Data()
+		}
+	case ReturnNew:
+		if newbie != nil {
+			result.New = newbie.Data()
+		}
+	case ReturnDiff:
+		result.Diff = bingodb.Diff(old, newbie)
+	default:
+		var oldDoc, newbieDoc bingodb.Data
+		if old != nil {
+			oldDoc = old.Data()
+		}
+		if newbie != nil {
+			newbieDoc = newbie.Data()
 		}
+		result.Old, result.New = oldDoc, newbieDoc
+	}
+	return result
+}
+
+// newRemoveResult responds the removed document, nothing for "none" and
+// "new", or the fields removed for "diff".
+func newRemoveResult(document *bingodb.Document, ret string) interface{} {
+	switch ret {
+	case ReturnNone, ReturnNew:
+		return gin.H{}
+	case ReturnDiff:
+		return bingodb.Diff(document, nil)
+	}
+	return document.Data()
+}
+
+func checkReturn(ret string) error {
+	switch ret {
+	case "", ReturnNone, ReturnOld, ReturnNew, ReturnDiff:
+		return nil
+	}
+	return fmt.Errorf(UnknownReturn, ret)
+}
+
+func newConflictResult(err *bingodb.ConditionError) *ConflictResult {
+	var current bingodb.Data
+	if err.Current != nil {
+		current = err.Current.Data()
 	}
+	return &ConflictResult{Error: err.Error(), Current: current}
+}
+
+func newBatchItemResult(write *bingodb.Write, result *bingodb.WriteResult, ret string) *BatchItemResult {
+	if conflict, ok := result.Err.(*bingodb.ConditionError); ok {
+		return &BatchItemResult{Status: http.StatusConflict, Body: newConflictResult(conflict)}
+	} else if result.Err != nil {
+		return &BatchItemResult{Status: http.StatusUnprocessableEntity, Body: &ErrorResult{Error: result.Err.Error()}}
+	}
+
+	if write.Update != nil {
+		return &BatchItemResult{Status: http.StatusOK, Body: newPutResult(result.Old, result.New, result.Replaced, ret)}
+	}
+	return &BatchItemResult{Status: http.StatusOK, Body: newRemoveResult(result.Old, ret)}
+}
 
+func newListResponse(values []bingodb.Data, next interface{}) *ScanResult {
 	return &ScanResult{Values: values, Next: next}
 }
 
@@ -85,7 +300,8 @@ func (rs *Resource) Get(ctx *gin.Context) {
 	if table, ok := rs.bingo.Table(ctx.Param("table")); ok {
 		if index := table.Index(ctx.Param("index")); index != nil {
 			if document, err := index.Get(ctx.Query("hash"), ctx.Query("sort")); err == nil {
-				ctx.JSON(http.StatusOK, document.Data())
+				ctx.Header("ETag", etag(document))
+				ctx.JSON(http.StatusOK, versionedData(document, fetchFields(ctx)))
 			} else {
 				ctx.Error(err)
 			}
@@ -100,20 +316,308 @@ func (rs *Resource) Get(ctx *gin.Context) {
 	rs.bingo.AddGet()
 }
 
+// MultiGet looks up a list of keys and responds documents in the same
+// order, with null for keys not found.
+func (rs *Resource) MultiGet(ctx *gin.Context) {
+	if table, ok := rs.bingo.Table(ctx.Param("table")); ok {
+		if index := table.Index(ctx.Param("index")); index != nil {
+			var keys []*KeyQuery
+			decoder := json.NewDecoder(ctx.Request.Body)
+			if err := decoder.Decode(&keys); err != nil {
+				ctx.Error(err)
+				return
+			}
+
+			fields := fetchFields(ctx)
+			values := make([]bingodb.Data, len(keys))
+			for i, key := range keys {
+				if document, err := index.Get(key.Hash, key.Sort); err == nil {
+					values[i] = document.Select(fields...)
+				} else if err.Error() != bingodb.DocumentNotFound {
+					ctx.Error(err)
+					return
+				}
+				rs.bingo.AddGet()
+			}
+
+			ctx.JSON(http.StatusOK, newListResponse(values, nil))
+		} else {
+			ctx.Error(errors.New(IndexNotFound))
+		}
+
+	} else {
+		ctx.Error(errors.New(TableNotFound))
+	}
+}
+
+// Scan responds a page of a partition, or of several partitions merged in
+// order with `hash` repeated, and an opaque `next` to pass as `cursor`.
 func (rs *Resource) Scan(ctx *gin.Context) {
 	if table, ok := rs.bingo.Table(ctx.Param("table")); ok {
 		if index := table.Index(ctx.Param("index")); index != nil {
 			query := rs.fetchScanQuery(ctx)
-			var values []bingodb.Data
-			var next interface{}
+			cursor, err := rs.fetchCursor(ctx, scanCursor, mergedCursor)
+			if cursor != nil {
+				cursor.restore(&query)
+			}
+			if err == nil {
+				query.Limit, err = fetchLimit(ctx)
+			}
+
+			var filter *bingodb.Filter
+			if err == nil {
+				filter, err = bingodb.ParseFilter(query.Filter)
+			}
+
+			if err == nil {
+				options := &bingodb.ScanOptions{
+					Since:          query.Since,
+					Until:          query.Until,
+					Prefix:         query.Prefix,
+					SinceExclusive: query.SinceExclusive,
+					UntilExclusive: query.UntilExclusive,
+					Limit:          query.Limit,
+					Offset:         query.Offset,
+					Backward:       query.Backward,
+					Filter:         filter,
+					MaxExamined:    query.MaxExamined,
+					Fields:         fetchFields(ctx),
+				}
+
+				if (cursor != nil && cursor.Kind == mergedCursor) || (cursor == nil && len(ctx.QueryArray("hash")) > 1) {
+					if query.Snapshot {
+						ctx.Error(errors.New(SnapshotNotMerged))
+					} else {
+						rs.scanMerged(ctx, index, &query, cursor, options)
+					}
+				} else if query.Snapshot {
+					rs.scanSnapshot(ctx, table, &query, cursor, options)
+				} else if values, next, err := index.Query(query.HashKey, options); err != nil && err.Error() != bingodb.HashKeyMissing {
+					ctx.Error(err)
+				} else {
+					// A scan without hash keeps responding an empty page
+					result := newListResponse(values, nil)
+					if next != nil {
+						cursor := newCursor(ctx, scanCursor, &query)
+						cursor.Since = sortKeyTuple(next)
+						result.Next = cursor.Token(rs.cursorKey)
+					}
+					ctx.JSON(http.StatusOK, result)
+				}
+			} else {
+				ctx.Error(err)
+			}
+		} else {
+			ctx.Error(errors.New(IndexNotFound))
+		}
+
+	} else {
+		ctx.Error(errors.New(TableNotFound))
+	}
+	rs.bingo.AddScan()
+}
+
+// scanSnapshot responds a page of a partition as of the version pinned by
+// cursor, or pins the current version of the table for the first page.
+func (rs *Resource) scanSnapshot(ctx *gin.Context, table *bingodb.Table, query *ScanQuery, cursor *Cursor, options *bingodb.ScanOptions) {
+	var version int64
+	if cursor != nil && cursor.Snapshot != nil {
+		version = *cursor.Snapshot
+	} else if pinned, err := table.Snapshot(); err == nil {
+		version = pinned
+	} else {
+		ctx.Error(err)
+		return
+	}
+
+	values, next, err := table.QueryAsOf(ctx.Param("index"), query.HashKey, options, version)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	result := newListResponse(values, nil)
+	if next != nil {
+		cursor := newCursor(ctx, scanCursor, query)
+		cursor.Since = sortKeyTuple(next)
+		cursor.Snapshot = &version
+		result.Next = cursor.Token(rs.cursorKey)
+	}
+	ctx.JSON(http.StatusOK, result)
+}
+
+// scanMerged responds a page of the partitions of every `hash` merged in
+// order, or of those left in cursor, with a cursor of those left after it.
+func (rs *Resource) scanMerged(ctx *gin.Context, index bingodb.RangeIndex, query *ScanQuery, cursor *Cursor, options *bingodb.ScanOptions) {
+	cursors := make([]*bingodb.PartitionCursor, 0)
+	if cursor != nil {
+		for _, partition := range cursor.Partitions {
+			cursors = append(cursors, &bingodb.PartitionCursor{Hash: partition.Hash, Since: partition.Since})
+		}
+	} else {
+		for _, hash := range ctx.QueryArray("hash") {
+			cursors = append(cursors, &bingodb.PartitionCursor{Hash: hash})
+		}
+	}
+
+	values, next, err := index.QueryMerged(cursors, options)
+	if err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	result := newListResponse(values, nil)
+	if len(next) > 0 {
+		cursor := newCursor(ctx, mergedCursor, query)
+		cursor.Hash = nil
+		for _, partition := range next {
+			cursor.Partitions = append(cursor.Partitions, &PartitionCursorQuery{Hash: partition.Hash, Since: sortKeyTuple(partition.Since)})
+		}
+		result.Next = cursor.Token(rs.cursorKey)
+	}
+	ctx.JSON(http.StatusOK, result)
+}
+
+// All responds `limit` (20 by default) documents of a table across all of
+// its partitions, from `cursor`, the `next` of a previous page.
+func (rs *Resource) All(ctx *gin.Context) {
+	if table, ok := rs.bingo.Table(ctx.Param("table")); ok {
+		if limit, err := fetchLimit(ctx); err != nil {
+			ctx.Error(err)
+		} else if cursor, err := rs.fetchCursor(ctx, allCursor); err == nil {
+			var hash, sort interface{}
+			if cursor != nil && len(cursor.Since) > 0 {
+				hash, sort = cursor.Hash, cursor.Since[0]
+			}
+
+			values, next, _ := table.PrimaryIndex().All(hash, sort, limit, fetchFields(ctx))
+			result := newListResponse(values, nil)
+			if next != nil {
+				key := next.([]interface{})
+				cursor := newCursor(ctx, allCursor, &ScanQuery{HashKey: key[0]})
+				cursor.Since = sortKeyTuple(key[1])
+				result.Next = cursor.Token(rs.cursorKey)
+			}
+			ctx.JSON(http.StatusOK, result)
+		} else {
+			ctx.Error(err)
+		}
+	} else {
+		ctx.Error(errors.New(TableNotFound))
+	}
+	rs.bingo.AddScan()
+}
+
+// Count responds the number of documents of a partition in the range and
+// matching the filter of a scan.
+func (rs *Resource) Count(ctx *gin.Context) {
+	if table, ok := rs.bingo.Table(ctx.Param("table")); ok {
+		if index := table.Index(ctx.Param("index")); index != nil {
+			query := rs.fetchScanQuery(ctx)
+			if filter, err := bingodb.ParseFilter(ctx.Query("filter")); err != nil {
+				ctx.Error(err)
+			} else if count, err := index.Count(query.HashKey, &bingodb.ScanOptions{
+				Since:          query.Since,
+				Until:          query.Until,
+				Prefix:         query.Prefix,
+				SinceExclusive: query.SinceExclusive,
+				UntilExclusive: query.UntilExclusive,
+				Backward:       query.Backward,
+				Filter:         filter,
+			}); err != nil {
+				ctx.Error(err)
+			} else {
+				ctx.JSON(http.StatusOK, &CountResult{Count: count})
+			}
+		} else {
+			ctx.Error(errors.New(IndexNotFound))
+		}
+
+	} else {
+		ctx.Error(errors.New(TableNotFound))
+	}
+	rs.bingo.AddScan()
+}
+
+// Rank responds the 0-based position of the document with `hash` and
+// `sort` in its partition, and the size of the partition.
+func (rs *Resource) Rank(ctx *gin.Context) {
+	if table, ok := rs.bingo.Table(ctx.Param("table")); ok {
+		if index := table.Index(ctx.Param("index")); index != nil {
+			hash := ctx.Query("hash")
+			if rank, err := index.Rank(hash, fetchSortKey(ctx, "sort")); err != nil {
+				ctx.Error(err)
+			} else if size, err := index.Count(hash, &bingodb.ScanOptions{}); err != nil {
+				ctx.Error(err)
+			} else {
+				ctx.JSON(http.StatusOK, &RankResult{Rank: rank, Size: size})
+			}
+		} else {
+			ctx.Error(errors.New(IndexNotFound))
+		}
 
-			if query.Backward {
-				values, next, _ = index.RScan(query.HashKey, query.Since, query.Limit)
+	} else {
+		ctx.Error(errors.New(TableNotFound))
+	}
+	rs.bingo.AddScan()
+}
+
+// Aggregate responds sum, min, max or avg of a field over a partition in
+// the range and matching the filter of a scan, for each value of groupBy if given.
+func (rs *Resource) Aggregate(ctx *gin.Context) {
+	if table, ok := rs.bingo.Table(ctx.Param("table")); ok {
+		query := rs.fetchScanQuery(ctx)
+		aggregation := &bingodb.Aggregation{Op: ctx.Query("op"), Field: ctx.Query("field"), GroupBy: ctx.Query("groupBy")}
+		if filter, err := bingodb.ParseFilter(ctx.Query("filter")); err != nil {
+			ctx.Error(err)
+		} else if results, err := table.Aggregate(ctx.Param("index"), query.HashKey, &bingodb.ScanOptions{
+			Since:          query.Since,
+			Until:          query.Until,
+			Prefix:         query.Prefix,
+			SinceExclusive: query.SinceExclusive,
+			UntilExclusive: query.UntilExclusive,
+			Backward:       query.Backward,
+			Filter:         filter,
+		}, aggregation); err != nil {
+			ctx.Error(err)
+		} else {
+			groups := make([]*AggregateResult, len(results))
+			for i, result := range results {
+				groups[i] = &AggregateResult{Group: result.Group, Value: result.Value, Count: result.Count}
+			}
+			if len(aggregation.GroupBy) == 0 {
+				ctx.JSON(http.StatusOK, groups[0])
 			} else {
-				values, next, _ = index.Scan(query.HashKey, query.Since, query.Limit)
+				ctx.JSON(http.StatusOK, &AggregateGroupsResult{Groups: groups})
 			}
+		}
+	} else {
+		ctx.Error(errors.New(TableNotFound))
+	}
+	rs.bingo.AddScan()
+}
 
-			ctx.JSON(http.StatusOK, newListResponse(values, next))
+// Partitions responds hash values of a table or sub index in order with
+// the number of documents of each, `limit` (20 by default) after `after`.
+func (rs *Resource) Partitions(ctx *gin.Context) {
+	if table, ok := rs.bingo.Table(ctx.Param("table")); ok {
+		if index := table.Index(ctx.Param("index")); index != nil {
+			var after interface{}
+			if value, ok := ctx.GetQuery("after"); ok {
+				after = value
+			}
+
+			if limit, err := fetchLimit(ctx); err != nil {
+				ctx.Error(err)
+			} else if partitions, next, err := index.Partitions(after, limit); err == nil {
+				values := make([]*PartitionResult, len(partitions))
+				for i, partition := range partitions {
+					values[i] = &PartitionResult{Hash: partition.Hash, Size: partition.Size}
+				}
+				ctx.JSON(http.StatusOK, &ScanResult{Values: values, Next: next})
+			} else {
+				ctx.Error(err)
+			}
 		} else {
 			ctx.Error(errors.New(IndexNotFound))
 		}
@@ -129,10 +633,12 @@ func (rs *Resource) Put(ctx *gin.Context) {
 		decoder := json.NewDecoder(ctx.Request.Body)
 		var query PutQuery
 		if err := decoder.Decode(&query); err == nil {
-			if old, newbie, replaced, err := table.Put(&query.Set, &query.SetOnInsert); err == nil {
-				ctx.JSON(http.StatusOK, newPutResult(old, newbie, replaced))
-			} else {
+			if err := checkReturn(query.Return); err != nil {
 				ctx.Error(err)
+			} else if old, newbie, replaced, err := table.Apply(query.Update()); err == nil {
+				ctx.JSON(http.StatusOK, newPutResult(old, newbie, replaced, query.Return))
+			} else {
+				writeError(ctx, err)
 			}
 		} else {
 			ctx.Error(err)
@@ -145,10 +651,69 @@ func (rs *Resource) Put(ctx *gin.Context) {
 
 func (rs *Resource) Remove(ctx *gin.Context) {
 	if table, ok := rs.bingo.Table(ctx.Param("table")); ok {
-		if document, err := table.Remove(ctx.Query("hash"), ctx.Query("sort")); err == nil {
-			ctx.JSON(http.StatusOK, document.Data())
+		ret := ctx.Query("return")
+		if err := checkReturn(ret); err != nil {
+			ctx.Error(err)
+		} else if condition, err := fetchCondition(ctx); err != nil {
+			ctx.Error(err)
+		} else if document, err := table.RemoveIf(ctx.Query("hash"), ctx.Query("sort"), condition); err == nil {
+			ctx.JSON(http.StatusOK, newRemoveResult(document, ret))
 		} else {
+			writeError(ctx, err)
+		}
+	} else {
+		ctx.Error(errors.New(TableNotFound))
+	}
+	rs.bingo.AddRemove()
+}
+
+// RemoveRange removes every document of a partition from since to until,
+// both inclusive, and responds how many were removed.
+func (rs *Resource) RemoveRange(ctx *gin.Context) {
+	if table, ok := rs.bingo.Table(ctx.Param("table")); ok {
+		if index := table.Index(ctx.Param("index")); index != nil {
+			query := rs.fetchScanQuery(ctx)
+			options := &bingodb.ScanOptions{
+				Since:          query.Since,
+				Until:          query.Until,
+				Prefix:         query.Prefix,
+				SinceExclusive: query.SinceExclusive,
+				UntilExclusive: query.UntilExclusive,
+			}
+			if count, err := table.RemoveRange(ctx.Param("index"), query.HashKey, options); err == nil {
+				ctx.JSON(http.StatusOK, &CountResult{Count: int64(count)})
+			} else {
+				ctx.Error(err)
+			}
+		} else {
+			ctx.Error(errors.New(IndexNotFound))
+		}
+
+	} else {
+		ctx.Error(errors.New(TableNotFound))
+	}
+	rs.bingo.AddRemove()
+}
+
+// RemoveByIndex removes the document found with hash and sort on a sub
+// index, or with all=true every document having them, every document of
+// the partition if sort is not given.
+func (rs *Resource) RemoveByIndex(ctx *gin.Context) {
+	if table, ok := rs.bingo.Table(ctx.Param("table")); ok {
+		ret := ctx.Query("return")
+		all, _ := strconv.ParseBool(ctx.Query("all"))
+		if err := checkReturn(ret); err != nil {
+			ctx.Error(err)
+		} else if documents, err := table.RemoveByIndex(ctx.Param("index"), ctx.Query("hash"), ctx.Query("sort"), all); err != nil {
 			ctx.Error(err)
+		} else if all {
+			values := make([]interface{}, len(documents))
+			for i, document := range documents {
+				values[i] = newRemoveResult(document, ret)
+			}
+			ctx.JSON(http.StatusOK, &ScanResult{Values: values})
+		} else {
+			ctx.JSON(http.StatusOK, newRemoveResult(documents[0], ret))
 		}
 	} else {
 		ctx.Error(errors.New(TableNotFound))
@@ -156,6 +721,215 @@ func (rs *Resource) Remove(ctx *gin.Context) {
 	rs.bingo.AddRemove()
 }
 
+// PutByIndex applies an update to the document found with hash and sort on
+// a sub index, or with all=true to every document having them.
+// It never inserts a document.
+func (rs *Resource) PutByIndex(ctx *gin.Context) {
+	if table, ok := rs.bingo.Table(ctx.Param("table")); ok {
+		decoder := json.NewDecoder(ctx.Request.Body)
+		var query PutQuery
+		if err := decoder.Decode(&query); err != nil {
+			ctx.Error(err)
+			return
+		}
+
+		if err := checkReturn(query.Return); err != nil {
+			ctx.Error(err)
+			return
+		}
+
+		update := query.Update()
+		all, _ := strconv.ParseBool(ctx.Query("all"))
+		if results, err := table.ApplyByIndex(ctx.Param("index"), ctx.Query("hash"), ctx.Query("sort"), all, update); err != nil {
+			ctx.Error(err)
+		} else if all {
+			write := &bingodb.Write{Table: table, Update: update}
+			items := make([]*BatchItemResult, len(results))
+			for i, result := range results {
+				items[i] = newBatchItemResult(write, result, query.Return)
+			}
+			ctx.JSON(http.StatusOK, &BatchResult{Results: items})
+		} else if result := results[0]; result.Err != nil {
+			writeError(ctx, result.Err)
+		} else {
+			ctx.JSON(http.StatusOK, newPutResult(result.Old, result.New, result.Replaced, query.Return))
+		}
+	} else {
+		ctx.Error(errors.New(TableNotFound))
+	}
+	rs.bingo.AddPut()
+}
+
+// writeError responds 409 with the current document when the condition of
+// a write fails, other errors are left to the error handler.
+func writeError(ctx *gin.Context, err error) {
+	if conflict, ok := err.(*bingodb.ConditionError); ok {
+		ctx.JSON(http.StatusConflict, newConflictResult(conflict))
+	} else {
+		ctx.Error(err)
+	}
+}
+
+// fetchCondition decodes the `if` query parameter, which takes the same
+// JSON object as $if of PUT, and adds `expectedVersion` to it.
+func fetchCondition(ctx *gin.Context) (*bingodb.Data, error) {
+	query := &DeleteQuery{}
+
+	if value, ok := ctx.GetQuery("if"); ok {
+		if err := json.Unmarshal([]byte(value), &query.If); err != nil {
+			return nil, err
+		}
+	}
+
+	if value, ok := ctx.GetQuery("expectedVersion"); ok {
+		version, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		query.ExpectedVersion = &version
+	}
+
+	return query.Condition(), nil
+}
+
+// versionedData returns the fields of a document with its version as VersionField.
+func versionedData(document *bingodb.Document, fields []string) bingodb.Data {
+	data := make(bingodb.Data)
+	for k, v := range document.Select(fields...) {
+		data[k] = v
+	}
+	data[VersionField] = document.Version()
+	return data
+}
+
+func etag(document *bingodb.Document) string {
+	return fmt.Sprintf("\"%d\"", document.Version())
+}
+
+// Batch applies a list of puts and deletes, on a single table when
+// requested with the table in path, otherwise on the table of each item.
+func (rs *Resource) Batch(ctx *gin.Context) {
+	name := ctx.Param("table")
+	if _, ok := rs.bingo.Table(name); len(name) > 0 && !ok {
+		ctx.Error(errors.New(TableNotFound))
+		return
+	}
+
+	var queries []*WriteQuery
+	decoder := json.NewDecoder(ctx.Request.Body)
+	if err := decoder.Decode(&queries); err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	writes := make([]*bingodb.Write, 0, len(queries))
+	results := make([]*BatchItemResult, len(queries))
+	for i, query := range queries {
+		tableName := query.Table
+		if len(name) > 0 {
+			tableName = name
+		}
+		table, ok := rs.bingo.Table(tableName)
+		if !ok {
+			results[i] = &BatchItemResult{Status: http.StatusUnprocessableEntity, Body: &ErrorResult{Error: TableNotFound}}
+			continue
+		}
+
+		if err := checkReturn(query.Return()); err != nil {
+			results[i] = &BatchItemResult{Status: http.StatusUnprocessableEntity, Body: &ErrorResult{Error: err.Error()}}
+		} else if query.Put != nil && query.Delete == nil {
+			writes = append(writes, &bingodb.Write{Table: table, Update: query.Put.Update()})
+			rs.bingo.AddPut()
+		} else if query.Delete != nil && query.Put == nil {
+			writes = append(writes, &bingodb.Write{
+				Table: table,
+				Hash:  query.Delete.Hash,
+				Sort:  query.Delete.Sort,
+				If:    query.Delete.Condition(),
+			})
+			rs.bingo.AddRemove()
+		} else {
+			results[i] = &BatchItemResult{Status: http.StatusUnprocessableEntity, Body: &ErrorResult{Error: PutOrDeleteMissing}}
+		}
+	}
+
+	writeResults := rs.bingo.Batch(writes)
+	for i, j := 0, 0; i < len(results); i++ {
+		if results[i] == nil {
+			results[i] = newBatchItemResult(writes[j], writeResults[j], queries[i].Return())
+			j++
+		}
+	}
+
+	ctx.JSON(http.StatusOK, &BatchResult{Results: results})
+}
+
+// Transact applies a list of puts and deletes across tables all or nothing.
+// It responds results like Batch, or the index and error of the failed write.
+func (rs *Resource) Transact(ctx *gin.Context) {
+	var queries []*WriteQuery
+	decoder := json.NewDecoder(ctx.Request.Body)
+	if err := decoder.Decode(&queries); err != nil {
+		ctx.Error(err)
+		return
+	}
+
+	writes := make([]*bingodb.Write, len(queries))
+	for i, query := range queries {
+		table, ok := rs.bingo.Table(query.Table)
+		if !ok {
+			ctx.Error(errors.New(TableNotFound))
+			return
+		}
+
+		if err := checkReturn(query.Return()); err != nil {
+			ctx.Error(err)
+			return
+		}
+
+		if query.Put != nil && query.Delete == nil {
+			writes[i] = &bingodb.Write{Table: table, Update: query.Put.Update()}
+		} else if query.Delete != nil && query.Put == nil {
+			writes[i] = &bingodb.Write{
+				Table: table,
+				Hash:  query.Delete.Hash,
+				Sort:  query.Delete.Sort,
+				If:    query.Delete.Condition(),
+			}
+		} else {
+			ctx.Error(errors.New(PutOrDeleteMissing))
+			return
+		}
+	}
+
+	writeResults, err := rs.bingo.Transact(writes)
+	if transactionErr, ok := err.(*bingodb.TransactionError); ok {
+		result := &TransactionErrorResult{Error: transactionErr.Err.Error(), Index: transactionErr.Index}
+		if conflict, ok := transactionErr.Err.(*bingodb.ConditionError); ok {
+			result.Current = newConflictResult(conflict).Current
+			ctx.JSON(http.StatusConflict, result)
+		} else {
+			ctx.JSON(http.StatusUnprocessableEntity, result)
+		}
+		return
+	} else if err != nil {
+		writeError(ctx, err)
+		return
+	}
+
+	results := make([]*BatchItemResult, len(writes))
+	for i, write := range writes {
+		results[i] = newBatchItemResult(write, writeResults[i], queries[i].Return())
+		if write.Update != nil {
+			rs.bingo.AddPut()
+		} else {
+			rs.bingo.AddRemove()
+		}
+	}
+
+	ctx.JSON(http.StatusOK, &BatchResult{Results: results})
+}
+
 func (rs *Resource) fetchTable(ctx *gin.Context) *bingodb.Table {
 	tableName := ctx.Param("table")
 	if table, ok := rs.bingo.Table(tableName); ok {
@@ -171,21 +945,18 @@ func (rs *Resource) fetchScanQuery(ctx *gin.Context) (query ScanQuery) {
 		query.HashKey = string(value)
 	}
 
-	query.Since = make([]interface{}, 3)
+	query.Since = fetchSortKey(ctx, "since")
+	query.Until = fetchSortKey(ctx, "until")
+	query.Prefix = ctx.Query("prefix")
+	query.Filter = ctx.Query("filter")
 
-	if ary, ok := ctx.GetQueryArray("since"); ok {
-		for i, value := range ary {
-			if i >= 3 {
-				break
-			}
-			query.Since[i] = value
-		}
-	}
+	query.Offset, _ = strconv.Atoi(ctx.Query("offset"))
 
-	if value, ok := ctx.GetQuery("limit"); ok {
-		query.Limit, _ = strconv.Atoi(value)
-	} else {
-		query.Limit = 20
+	query.SinceExclusive, _ = strconv.ParseBool(ctx.Query("sinceExclusive"))
+	query.UntilExclusive, _ = strconv.ParseBool(ctx.Query("untilExclusive"))
+
+	if value, ok := ctx.GetQuery("maxExamined"); ok {
+		query.MaxExamined, _ = strconv.Atoi(value)
 	}
 
 	if value, ok := ctx.GetQuery("backward"); ok {
@@ -194,5 +965,50 @@ func (rs *Resource) fetchScanQuery(ctx *gin.Context) (query ScanQuery) {
 		query.Backward = false
 	}
 
+	query.Snapshot, _ = strconv.ParseBool(ctx.Query("snapshot"))
+
 	return
 }
+
+// fetchLimit reads `limit` of a page, 20 if not given. It fails unless
+// the limit is a positive integer, as a page of nothing never advances.
+func fetchLimit(ctx *gin.Context) (int, error) {
+	value, ok := ctx.GetQuery("limit")
+	if !ok {
+		return 20, nil
+	}
+	if limit, err := strconv.Atoi(value); err == nil && limit > 0 {
+		return limit, nil
+	}
+	return 0, errors.New(InvalidLimit)
+}
+
+// fetchFields reads the fields to respond given as `fields=a,b` or `fields=a&fields=b`.
+func fetchFields(ctx *gin.Context) []string {
+	fields := make([]string, 0)
+	for _, value := range ctx.QueryArray("fields") {
+		for _, field := range strings.Split(value, ",") {
+			if field = strings.TrimSpace(field); len(field) > 0 {
+				fields = append(fields, field)
+			}
+		}
+	}
+	return fields
+}
+
+// fetchSortKey reads a sort key given as up to three values of the query
+// parameter: the sort value, then the primary hash and sort for sub indices.
+func fetchSortKey(ctx *gin.Context, name string) []interface{} {
+	key := make([]interface{}, 3)
+
+	if ary, ok := ctx.GetQueryArray(name); ok {
+		for i, value := range ary {
+			if i >= 3 {
+				break
+			}
+			key[i] = value
+		}
+	}
+
+	return key
+}
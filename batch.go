@@ -0,0 +1,106 @@
+package bingodb
+
+import (
+	"sort"
+)
+
+// Write is a single write of a batch. A write with an Update puts a
+// document, otherwise it removes the document of Hash and Sort if the
+// condition If holds on it.
+type Write struct {
+	Table  *Table
+	Update *Update
+	Hash   interface{}
+	Sort   interface{}
+	If     *Data
+}
+
+// WriteResult is the outcome of a write. Err is a *ConditionError when
+// the condition of the write does not hold.
+type WriteResult struct {
+	Old      *Document
+	New      *Document
+	Replaced bool
+	Err      error
+}
+
+type pendingWrite struct {
+	put  *pendingPut
+	cond *condition
+}
+
+// Batch applies writes in order, locking each table involved only once.
+// Writes are independent of each other, so a failed write does not stop the others.
+func (bingo *Bingo) Batch(writes []*Write) []*WriteResult {
+	results := make([]*WriteResult, len(writes))
+	pending := make([]*pendingWrite, len(writes))
+
+	for i, write := range writes {
+		var err error
+		if pending[i], err = write.Table.prepareWrite(write); err != nil {
+			results[i] = &WriteResult{Err: err}
+		}
+	}
+
+	unlock := lockTables(writes)
+	defer unlock()
+
+	for i, write := range writes {
+		if results[i] != nil {
+			continue
+		}
+		results[i] = write.Table.write(write, pending[i])
+	}
+
+	return results
+}
+
+func (table *Table) prepareWrite(write *Write) (*pendingWrite, error) {
+	var err error
+	pending := &pendingWrite{}
+	if write.Update != nil {
+		pending.put, err = table.preparePut(write.Update)
+	} else {
+		pending.cond, err = table.parseCondition(write.If)
+	}
+	return pending, err
+}
+
+// write applies a prepared write. The caller must hold the table lock.
+func (table *Table) write(write *Write, pending *pendingWrite) *WriteResult {
+	if pending.put != nil {
+		old, newbie, replaced, err := table.put(pending.put)
+		return &WriteResult{Old: old, New: newbie, Replaced: replaced, Err: err}
+	}
+
+	old, err := table.remove(write.Hash, write.Sort, pending.cond)
+	return &WriteResult{Old: old, Err: err}
+}
+
+// lockTables locks every table of writes once, in the order of table names
+// so that concurrent batches never wait for each other in a cycle.
+// It returns a function releasing the locks.
+func lockTables(writes []*Write) func() {
+	tables := make([]*Table, 0)
+	seen := make(map[*Table]bool)
+	for _, write := range writes {
+		if !seen[write.Table] {
+			seen[write.Table] = true
+			tables = append(tables, write.Table)
+		}
+	}
+
+	sort.Slice(tables, func(i, j int) bool {
+		return tables[i].name < tables[j].name
+	})
+
+	for _, table := range tables {
+		table.mutex.Lock()
+	}
+
+	return func() {
+		for i := len(tables) - 1; i >= 0; i-- {
+			tables[i].mutex.Unlock()
+		}
+	}
+}
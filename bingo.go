@@ -11,6 +11,7 @@ type Bingo struct {
 	tables        map[string]*Table
 	keeper        *Keeper
 	systemMetrics *SystemMetrics
+	idempotency   *Idempotency
 	ServerConfig  *ServerConfig
 }
 
@@ -48,11 +49,17 @@ func newBingo() *Bingo {
 func (bingo *Bingo) Start() {
 	bingo.keeper.start()
 	bingo.systemMetrics.start()
+	if bingo.idempotency != nil {
+		bingo.idempotency.start()
+	}
 }
 
 func (bingo *Bingo) Stop() {
 	bingo.keeper.stop()
 	bingo.systemMetrics.stop()
+	if bingo.idempotency != nil {
+		bingo.idempotency.stop()
+	}
 }
 
 func (bingo *Bingo) setTableMetrics() {
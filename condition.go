@@ -0,0 +1,139 @@
+package bingodb
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// ConditionError is returned when the condition of a write does not hold.
+// Current is the document the condition was checked against, nil if there was none.
+type ConditionError struct {
+	Current *Document
+}
+
+func (err *ConditionError) Error() string {
+	return ConditionFailed
+}
+
+// condition is a precondition on the current document of a write, e.g.
+// {"$exists": true, "status": "open", "updatedAt": {"$lt": 1505200000000}}
+// {"$version": 3} holds only if the current document has version 3,
+// {"$version": 0} only if there is no current document.
+type condition struct {
+	exists  *bool
+	version *int64
+	clauses []clause
+}
+
+type clause struct {
+	field string
+	op    string
+	value interface{}
+}
+
+func (table *Table) parseCondition(data *Data) (*condition, error) {
+	if data == nil || data.Length() == 0 {
+		return nil, nil
+	}
+
+	cond := &condition{}
+	for name, raw := range *data {
+		if name == "$exists" {
+			exists, ok := raw.(bool)
+			if !ok {
+				return nil, fmt.Errorf(InvalidCondition, name, raw)
+			}
+			cond.exists = &exists
+			continue
+		}
+		if name == "$version" {
+			number, ok := toNumber(raw)
+			if !ok {
+				return nil, fmt.Errorf(InvalidCondition, name, raw)
+			}
+			version := int64(toFloat(number))
+			cond.version = &version
+			continue
+		}
+
+		operators, ok := toModifiers(raw)
+		if !ok {
+			operators = map[string]interface{}{"$eq": raw}
+		}
+		for op, operand := range operators {
+			switch op {
+			case "$eq", "$ne", "$lt", "$lte", "$gt", "$gte":
+			default:
+				return nil, fmt.Errorf(InvalidCondition, name, raw)
+			}
+			value := operand
+			if field, ok := table.fields[name]; ok {
+				parsed, err := field.Parse(operand)
+				if err != nil {
+					return nil, err
+				}
+				value = parsed
+			} else if number, ok := toNumber(operand); ok {
+				value = number
+			}
+			cond.clauses = append(cond.clauses, clause{field: name, op: op, value: value})
+		}
+	}
+	return cond, nil
+}
+
+func (cond *condition) match(doc *Document) bool {
+	if cond == nil {
+		return true
+	}
+	if cond.exists != nil && *cond.exists != (doc != nil) {
+		return false
+	}
+	if cond.version != nil {
+		if doc == nil && *cond.version != 0 {
+			return false
+		}
+		if doc != nil && doc.version != *cond.version {
+			return false
+		}
+	}
+	for _, clause := range cond.clauses {
+		if !clause.match(doc) {
+			return false
+		}
+	}
+	return true
+}
+
+func (clause clause) match(doc *Document) bool {
+	if doc == nil {
+		return false
+	}
+
+	current, present := doc.data[clause.field]
+	if !present {
+		return clause.op == "$ne"
+	}
+
+	diff, ok := compareValues(current, clause.value)
+	if !ok {
+		equal := reflect.DeepEqual(current, clause.value)
+		return (clause.op == "$eq" && equal) || (clause.op == "$ne" && !equal)
+	}
+
+	switch clause.op {
+	case "$eq":
+		return diff == 0
+	case "$ne":
+		return diff != 0
+	case "$lt":
+		return diff < 0
+	case "$lte":
+		return diff <= 0
+	case "$gt":
+		return diff > 0
+	case "$gte":
+		return diff >= 0
+	}
+	return false
+}
@@ -17,24 +17,38 @@ type MetricsConfig struct {
 }
 
 type SubIndexConfig struct {
-	HashKey string `yaml:"hashKey"`
-	SortKey string `yaml:"sortKey"`
+	HashKey   string `yaml:"hashKey"`
+	SortKey   string `yaml:"sortKey"`
+	Order     string `yaml:"order"`
+	Collation string `yaml:"collation"`
 }
 
 type TableConfig struct {
 	Fields            map[string]string         `yaml:"fields"`
 	HashKey           string                    `yaml:"hashKey"`
 	SortKey           string                    `yaml:"sortKey"`
+	Order             string                    `yaml:"order"`
+	Collation         string                    `yaml:"collation"`
 	SubIndices        map[string]SubIndexConfig `yaml:"subIndices"`
 	ExpireKey         string                    `yaml:"expireKey"`
 	Metrics           *MetricsConfig            `yaml:"metrics"`
 	ExpireKeyRequired bool                      `yaml:"expireKeyRequired"`
+	// SnapshotTtl is how long in milliseconds a snapshot scan stays open
+	// after its last page, retaining documents replaced and removed since
+	// it began. Snapshot scans are disabled if not specified
+	SnapshotTtl int64 `yaml:"snapshotTtl"`
 }
 
 type ServerConfig struct {
 	Addr    string `yaml:"addr,omitempty"`
 	Logging bool   `yaml:"logging,omitempty"`
 	Mode    string `yaml:"mode,omitempty"`
+	// IdempotencyTtl is how long responses are remembered for an
+	// Idempotency-Key in milliseconds, one day if not specified
+	IdempotencyTtl int64 `yaml:"idempotencyTtl,omitempty"`
+	// CursorSecret signs scan cursors. Without it a random secret is used,
+	// and cursors issued before a restart are rejected
+	CursorSecret string `yaml:"cursorSecret,omitempty" json:"-"`
 }
 
 type BingoConfig struct {
@@ -47,6 +61,18 @@ const (
 	INTEGER = "integer"
 )
 
+const (
+	ASC  = "asc"
+	DESC = "desc"
+)
+
+const (
+	BINARY     = "binary"
+	NOCASE     = "nocase"
+	NFC        = "nfc"
+	NFC_NOCASE = "nfc_nocase"
+)
+
 // NewBingoFromConfigFile configuration file with specified path and
 // parse it to create source schema to prepare bingo.
 // It returns any error encountered.
@@ -94,8 +120,10 @@ func ParseConfigBytes(bingo *Bingo, configBytes []byte) error {
 		}
 
 		primaryKeySchema := &KeySchema{
-			hashKey: fields[tableConfig.HashKey],
-			sortKey: fields[tableConfig.SortKey],
+			hashKey:    fields[tableConfig.HashKey],
+			sortKey:    fields[tableConfig.SortKey],
+			descending: tableConfig.Order == DESC,
+			collation:  tableConfig.Collation,
 		}
 
 		tableSchema := &TableSchema{
@@ -109,8 +137,10 @@ func ParseConfigBytes(bingo *Bingo, configBytes []byte) error {
 
 		for indexName, indexConfig := range tableConfig.SubIndices {
 			subKeySchema := &KeySchema{
-				hashKey: fields[indexConfig.HashKey],
-				sortKey: fields[indexConfig.SortKey],
+				hashKey:    fields[indexConfig.HashKey],
+				sortKey:    fields[indexConfig.SortKey],
+				descending: indexConfig.Order == DESC,
+				collation:  indexConfig.Collation,
 			}
 
 			subIndices[indexName] = &SubIndex{
@@ -127,12 +157,18 @@ func ParseConfigBytes(bingo *Bingo, configBytes []byte) error {
 			subIndices,
 			tableConfig.Metrics,
 			tableConfig.ExpireKeyRequired)
+
+		if tableConfig.SnapshotTtl > 0 {
+			bingo.tables[tableName].history = newHistory(tableConfig.SnapshotTtl)
+		}
 	}
 
 	bingo.setTableMetrics()
 
 	bingo.ServerConfig = bingoConfig.ServerConfig
 
+	bingo.setIdempotency()
+
 	return nil
 }
 
@@ -165,6 +201,16 @@ func isValidTable(tableName string, tableInfo TableConfig) error {
 		return errors.New(fmt.Sprintf("%v - %v", format, err.Error()))
 	}
 
+	if err := isValidOrdering(tableInfo.Order, tableInfo.Collation); err != nil {
+		return errors.New(fmt.Sprintf("%v - %v", format, err.Error()))
+	}
+
+	for indexName, indexInfo := range tableInfo.SubIndices {
+		if err := isValidOrdering(indexInfo.Order, indexInfo.Collation); err != nil {
+			return errors.New(fmt.Sprintf("%v - %v in index '%v'", format, err.Error(), indexName))
+		}
+	}
+
 	return nil
 }
 
@@ -247,6 +293,23 @@ func isValidReferenceField(key string, name string, fields map[string]string) er
 	return nil
 }
 
+// check order and collation of an index are known values (empty means default)
+func isValidOrdering(order string, collation string) error {
+	switch order {
+	case "", ASC, DESC:
+	default:
+		return errors.New(fmt.Sprintf("unknown order '%v'", order))
+	}
+
+	switch collation {
+	case "", BINARY, NOCASE, NFC, NFC_NOCASE:
+	default:
+		return errors.New(fmt.Sprintf("unknown collation '%v'", collation))
+	}
+
+	return nil
+}
+
 func isAllowedFieldType(fieldType string) bool {
 	switch fieldType {
 	case
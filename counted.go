@@ -0,0 +1,175 @@
+package bingodb
+
+import (
+	"math/rand"
+	"sync"
+)
+
+const countedMaxLevel = 32
+
+// countedList is a skip list of keys counting the keys each link skips.
+type countedList struct {
+	mutex   *sync.RWMutex
+	compare func(a, b interface{}) int
+	head    *countedNode
+	level   int
+	size    int64
+}
+
+type countedNode struct {
+	key  interface{}
+	next []*countedNode
+	// span holds how many keys each link of next skips
+	span []int64
+}
+
+func newCountedList(compare func(a, b interface{}) int) *countedList {
+	return &countedList{
+		mutex:   new(sync.RWMutex),
+		compare: compare,
+		head:    &countedNode{next: make([]*countedNode, countedMaxLevel), span: make([]int64, countedMaxLevel)},
+		level:   1,
+	}
+}
+
+func randomCountedLevel() int {
+	level := 1
+	for level < countedMaxLevel && rand.Intn(4) == 0 {
+		level++
+	}
+	return level
+}
+
+// insert adds key, which must not be in the list yet.
+func (list *countedList) insert(key interface{}) {
+	list.mutex.Lock()
+	defer list.mutex.Unlock()
+
+	update := make([]*countedNode, countedMaxLevel)
+	// rank holds how many keys precede update at each level
+	rank := make([]int64, countedMaxLevel)
+	node := list.head
+	for i := list.level - 1; i >= 0; i-- {
+		if i < list.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for node.next[i] != nil && list.compare(node.next[i].key, key) < 0 {
+			rank[i] += node.span[i]
+			node = node.next[i]
+		}
+		update[i] = node
+	}
+
+	level := randomCountedLevel()
+	for i := list.level; i < level; i++ {
+		update[i] = list.head
+		list.head.span[i] = list.size
+	}
+	if level > list.level {
+		list.level = level
+	}
+
+	inserted := &countedNode{key: key, next: make([]*countedNode, level), span: make([]int64, level)}
+	for i := 0; i < level; i++ {
+		inserted.next[i] = update[i].next[i]
+		update[i].next[i] = inserted
+		inserted.span[i] = update[i].span[i] - (rank[0] - rank[i])
+		update[i].span[i] = rank[0] - rank[i] + 1
+	}
+	for i := level; i < list.level; i++ {
+		update[i].span[i]++
+	}
+	list.size++
+}
+
+// remove deletes key and reports whether it was in the list.
+func (list *countedList) remove(key interface{}) bool {
+	list.mutex.Lock()
+	defer list.mutex.Unlock()
+
+	update := make([]*countedNode, countedMaxLevel)
+	node := list.head
+	for i := list.level - 1; i >= 0; i-- {
+		for node.next[i] != nil && list.compare(node.next[i].key, key) < 0 {
+			node = node.next[i]
+		}
+		update[i] = node
+	}
+
+	removed := node.next[0]
+	if removed == nil || list.compare(removed.key, key) != 0 {
+		return false
+	}
+
+	for i := 0; i < list.level; i++ {
+		if update[i].next[i] == removed {
+			update[i].span[i] += removed.span[i] - 1
+			update[i].next[i] = removed.next[i]
+		} else {
+			update[i].span[i]--
+		}
+	}
+	for list.level > 1 && list.head.next[list.level-1] == nil {
+		list.level--
+	}
+	list.size--
+	return true
+}
+
+// rank returns how many keys precede bound by compare.
+func (list *countedList) rank(bound interface{}, compare func(key, bound interface{}) int) int64 {
+	list.mutex.RLock()
+	defer list.mutex.RUnlock()
+
+	var rank int64
+	node := list.head
+	for i := list.level - 1; i >= 0; i-- {
+		for node.next[i] != nil && compare(node.next[i].key, bound) < 0 {
+			rank += node.span[i]
+			node = node.next[i]
+		}
+	}
+	return rank
+}
+
+// rankThrough returns how many keys precede or equal bound by compare.
+func (list *countedList) rankThrough(bound interface{}, compare func(key, bound interface{}) int) int64 {
+	return list.rank(bound, func(key, bound interface{}) int {
+		if compare(key, bound) <= 0 {
+			return -1
+		}
+		return 1
+	})
+}
+
+// length returns the number of keys in the list.
+func (list *countedList) length() int64 {
+	list.mutex.RLock()
+	defer list.mutex.RUnlock()
+
+	return list.size
+}
+
+// at returns the key at the 0-based position, false if out of the list.
+func (list *countedList) at(position int64) (interface{}, bool) {
+	list.mutex.RLock()
+	defer list.mutex.RUnlock()
+
+	if position < 0 || position >= list.size {
+		return nil, false
+	}
+
+	// Keys are counted from 1 past the head
+	var traversed int64
+	node := list.head
+	for i := list.level - 1; i >= 0; i-- {
+		for node.next[i] != nil && traversed+node.span[i] <= position+1 {
+			traversed += node.span[i]
+			node = node.next[i]
+		}
+		if traversed == position+1 {
+			return node.key, true
+		}
+	}
+	return nil, false
+}
@@ -2,13 +2,15 @@ package bingodb
 
 import (
 	"encoding/json"
+	"sort"
 )
 
 type Data map[string]interface{}
 
 type Document struct {
-	data   Data
-	schema *TableSchema
+	data    Data
+	schema  *TableSchema
+	version int64
 }
 
 func (data *Data) Length() int {
@@ -60,6 +62,40 @@ func (doc *Document) Merge(op *Document) *Document {
 	return &Document{data: newbie, schema: doc.schema}
 }
 
+// Changes is the field level difference between two documents.
+type Changes struct {
+	Set   Data     `json:"set,omitempty"`
+	Unset []string `json:"unset,omitempty"`
+}
+
+// Diff returns the fields of newbie which are added or changed from old,
+// and the names of the fields of old which are removed. A nil old or
+// newbie has no fields.
+func Diff(old *Document, newbie *Document) *Changes {
+	changes := &Changes{Set: make(Data), Unset: make([]string, 0)}
+	var before, after Data
+	if old != nil {
+		before = old.data
+	}
+	if newbie != nil {
+		after = newbie.data
+	}
+
+	for k, v := range after {
+		if current, present := before[k]; !present || !equalValues(current, v) {
+			changes.Set[k] = v
+		}
+	}
+	for k := range before {
+		if _, present := after[k]; !present {
+			changes.Unset = append(changes.Unset, k)
+		}
+	}
+	sort.Strings(changes.Unset)
+
+	return changes
+}
+
 func ParseDoc(data *Data, schema *TableSchema) (*Document, error) {
 	//for doc, parsing nil equivalent to success
 	if data == nil || data.Length() == 0 {
@@ -84,6 +120,28 @@ func (doc *Document) Data() Data {
 	return doc.data
 }
 
+// Select returns only the given fields of the document, or all of them if
+// none is given. Fields missing in the document are left out.
+func (doc *Document) Select(fields ...string) Data {
+	if len(fields) == 0 {
+		return doc.data
+	}
+
+	data := make(Data)
+	for _, field := range fields {
+		if value, ok := doc.data[field]; ok {
+			data[field] = value
+		}
+	}
+	return data
+}
+
+// Version returns the version assigned to the document by its last write.
+// Versions increase monotonically within a table.
+func (doc *Document) Version() int64 {
+	return doc.version
+}
+
 func (doc *Document) ToJSON() []byte {
 	bytes, err := json.Marshal(doc.data)
 	if err != nil {
@@ -1,10 +1,28 @@
 package bingodb
 
 const (
-	FieldError         = "field '%s' is defined as %s type but value '%v' cannot be parsed"
-	SetOrInsertMissing = "set or setOnInsert are required"
-	HashKeyMissing     = "hash key is missing in set"
-	SortKeyMissing     = "sort key is missing in set"
-	ExpireKeyMissing   = "expire key is missing in set"
-	DocumentNotFound   = "document not found"
+	FieldError            = "field '%s' is defined as %s type but value '%v' cannot be parsed"
+	SetOrInsertMissing    = "set or setOnInsert are required"
+	HashKeyMissing        = "hash key is missing in set"
+	SortKeyMissing        = "sort key is missing in set"
+	ExpireKeyMissing      = "expire key is missing in set"
+	DocumentNotFound      = "document not found"
+	IndexNotFound         = "index not found"
+	KeyFieldModified      = "field '%s' cannot be modified by %s"
+	FieldNotNumeric       = "field '%s' cannot be incremented by '%v'"
+	FieldNotComparable    = "field '%s' cannot be compared with '%v'"
+	FieldNotArray         = "field '%s' is not an array"
+	ArrayOperandError     = "invalid operand of %s for field '%s'"
+	InvalidCondition      = "invalid condition on field '%s': '%v'"
+	ConditionFailed       = "condition failed"
+	TransactionFailed     = "write %d of transaction failed: %v"
+	UnknownMode           = "unknown mode '%s'"
+	RequestInProgress     = "request with the same idempotency key is in progress"
+	IdempotencyKeyReused  = "idempotency key was used with a different request body"
+	InvalidFilter         = "invalid filter '%s': %s"
+	UnknownAggregation    = "unknown aggregation '%s'"
+	AggregateFieldMissing = "field to aggregate is required"
+	PrefixNotString       = "prefix requires a string sort key"
+	SnapshotDisabled      = "snapshots are not enabled on the table"
+	SnapshotExpired       = "snapshot expired"
 )
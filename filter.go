@@ -0,0 +1,303 @@
+package bingodb
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Filter is a predicate on documents parsed from an expression like
+// personType == "user" && (updatedAt > 1505200000000 || !(lastSeen <= 10)).
+// Comparisons are ==, !=, <, <=, > and >= between a field and a string,
+// number, true, false or null. A missing field equals only null.
+type Filter struct {
+	node filterNode
+}
+
+type filterNode interface {
+	match(doc *Document) bool
+}
+
+type andNode struct {
+	left, right filterNode
+}
+
+type orNode struct {
+	left, right filterNode
+}
+
+type notNode struct {
+	node filterNode
+}
+
+type nullNode struct {
+	field string
+	equal bool
+}
+
+func (node *andNode) match(doc *Document) bool {
+	return node.left.match(doc) && node.right.match(doc)
+}
+
+func (node *orNode) match(doc *Document) bool {
+	return node.left.match(doc) || node.right.match(doc)
+}
+
+func (node *notNode) match(doc *Document) bool {
+	return !node.node.match(doc)
+}
+
+func (node *nullNode) match(doc *Document) bool {
+	return (doc.data[node.field] == nil) == node.equal
+}
+
+// Match reports whether doc satisfies the filter. A nil filter matches every document.
+func (filter *Filter) Match(doc *Document) bool {
+	if filter == nil {
+		return true
+	}
+	return filter.node.match(doc)
+}
+
+var filterOperators = map[string]string{
+	"==": "$eq",
+	"!=": "$ne",
+	"<":  "$lt",
+	"<=": "$lte",
+	">":  "$gt",
+	">=": "$gte",
+}
+
+// ParseFilter parses a filter expression. An empty expression is a nil filter.
+func ParseFilter(expression string) (*Filter, error) {
+	if len(strings.TrimSpace(expression)) == 0 {
+		return nil, nil
+	}
+
+	parser := &filterParser{expression: expression}
+	if err := parser.tokenize(); err != nil {
+		return nil, err
+	}
+
+	node, err := parser.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if parser.pos < len(parser.tokens) {
+		return nil, parser.errorf("unexpected '%s'", parser.tokens[parser.pos].text)
+	}
+	return &Filter{node: node}, nil
+}
+
+const (
+	identToken = iota
+	stringToken
+	numberToken
+	symbolToken
+)
+
+type filterToken struct {
+	kind int
+	text string
+}
+
+type filterParser struct {
+	expression string
+	tokens     []filterToken
+	pos        int
+}
+
+func (parser *filterParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf(InvalidFilter, parser.expression, fmt.Sprintf(format, args...))
+}
+
+func (parser *filterParser) tokenize() error {
+	runes := []rune(parser.expression)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+
+		case r == '"':
+			j := i + 1
+			for ; j < len(runes) && runes[j] != '"'; j++ {
+				if runes[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(runes) {
+				return parser.errorf("unterminated string")
+			}
+			value, err := strconv.Unquote(string(runes[i : j+1]))
+			if err != nil {
+				return parser.errorf("invalid string %s", string(runes[i:j+1]))
+			}
+			parser.tokens = append(parser.tokens, filterToken{stringToken, value})
+			i = j + 1
+
+		case unicode.IsDigit(r) || (r == '-' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			j := i + 1
+			for ; j < len(runes) && (unicode.IsDigit(runes[j]) || strings.ContainsRune(".eE+-", runes[j])); j++ {
+			}
+			parser.tokens = append(parser.tokens, filterToken{numberToken, string(runes[i:j])})
+			i = j
+
+		case unicode.IsLetter(r) || r == '_' || r == '$':
+			j := i + 1
+			for ; j < len(runes) && (unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j]) || strings.ContainsRune("_$.-", runes[j])); j++ {
+			}
+			parser.tokens = append(parser.tokens, filterToken{identToken, string(runes[i:j])})
+			i = j
+
+		default:
+			symbol := ""
+			for _, candidate := range []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!", "(", ")"} {
+				if strings.HasPrefix(string(runes[i:]), candidate) {
+					symbol = candidate
+					break
+				}
+			}
+			if len(symbol) == 0 {
+				return parser.errorf("unexpected '%c'", r)
+			}
+			parser.tokens = append(parser.tokens, filterToken{symbolToken, symbol})
+			i += len(symbol)
+		}
+	}
+	return nil
+}
+
+func (parser *filterParser) peek(symbol string) bool {
+	if parser.pos >= len(parser.tokens) {
+		return false
+	}
+	token := parser.tokens[parser.pos]
+	return token.kind == symbolToken && token.text == symbol
+}
+
+func (parser *filterParser) next() (filterToken, error) {
+	if parser.pos >= len(parser.tokens) {
+		return filterToken{}, parser.errorf("unexpected end")
+	}
+	token := parser.tokens[parser.pos]
+	parser.pos++
+	return token, nil
+}
+
+func (parser *filterParser) parseOr() (filterNode, error) {
+	left, err := parser.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for parser.peek("||") {
+		parser.pos++
+		right, err := parser.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &orNode{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (parser *filterParser) parseAnd() (filterNode, error) {
+	left, err := parser.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for parser.peek("&&") {
+		parser.pos++
+		right, err := parser.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &andNode{left: left, right: right}
+	}
+	return left, nil
+}
+
+func (parser *filterParser) parseUnary() (filterNode, error) {
+	if parser.peek("!") {
+		parser.pos++
+		node, err := parser.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{node: node}, nil
+	}
+
+	if parser.peek("(") {
+		parser.pos++
+		node, err := parser.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !parser.peek(")") {
+			return nil, parser.errorf("missing ')'")
+		}
+		parser.pos++
+		return node, nil
+	}
+
+	return parser.parseComparison()
+}
+
+func (parser *filterParser) parseComparison() (filterNode, error) {
+	field, err := parser.next()
+	if err != nil {
+		return nil, err
+	}
+	if field.kind != identToken {
+		return nil, parser.errorf("expected a field but '%s'", field.text)
+	}
+
+	operator, err := parser.next()
+	if err != nil {
+		return nil, err
+	}
+	op, ok := filterOperators[operator.text]
+	if operator.kind != symbolToken || !ok {
+		return nil, parser.errorf("expected a comparison but '%s'", operator.text)
+	}
+
+	operand, err := parser.next()
+	if err != nil {
+		return nil, err
+	}
+
+	var value interface{}
+	switch operand.kind {
+	case stringToken:
+		value = operand.text
+
+	case numberToken:
+		number, ok := toNumber(json.Number(operand.text))
+		if !ok {
+			return nil, parser.errorf("invalid number %s", operand.text)
+		}
+		value = number
+
+	case identToken:
+		switch operand.text {
+		case "true":
+			value = true
+		case "false":
+			value = false
+		case "null":
+			if op != "$eq" && op != "$ne" {
+				return nil, parser.errorf("null can be compared only by == and !=")
+			}
+			return &nullNode{field: field.text, equal: op == "$eq"}, nil
+		default:
+			return nil, parser.errorf("expected a value but '%s'", operand.text)
+		}
+
+	default:
+		return nil, parser.errorf("expected a value but '%s'", operand.text)
+	}
+
+	return &clause{field: field.text, op: op, value: value}, nil
+}
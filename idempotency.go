@@ -0,0 +1,145 @@
+package bingodb
+
+import (
+	"errors"
+	"github.com/robfig/cron"
+	"sync"
+	"time"
+)
+
+const defaultIdempotencyTtl = int64(24 * time.Hour / time.Millisecond)
+
+// Idempotency remembers responses by idempotency key for ttl. It keeps
+// them apart from the tables, so they are neither listed nor written
+// like documents.
+type Idempotency struct {
+	mutex     *sync.Mutex
+	responses map[string]*idempotentRequest
+	// expiries holds keys in the order they expire in
+	expiries []*idempotencyExpiry
+	ttl      time.Duration
+	cron     *cron.Cron
+}
+
+// Response is a response remembered for an idempotency key.
+type Response struct {
+	Status int
+	Body   []byte
+}
+
+// idempotentRequest is a request reserving a key, with the digest of its
+// body and its response once it finished.
+type idempotentRequest struct {
+	digest   string
+	response *Response
+	expireAt time.Time
+}
+
+type idempotencyExpiry struct {
+	key      string
+	expireAt time.Time
+}
+
+func (bingo *Bingo) setIdempotency() {
+	ttl := defaultIdempotencyTtl
+	if config := bingo.ServerConfig; config != nil && config.IdempotencyTtl > 0 {
+		ttl = config.IdempotencyTtl
+	}
+
+	idempotency := &Idempotency{
+		mutex:     new(sync.Mutex),
+		responses: make(map[string]*idempotentRequest),
+		expiries:  make([]*idempotencyExpiry, 0),
+		ttl:       time.Millisecond * time.Duration(ttl),
+		cron:      cron.New(),
+	}
+	idempotency.cron.AddFunc("@every 1s", idempotency.sweep)
+	bingo.idempotency = idempotency
+}
+
+// ReserveResponse reserves key for a request about to run with a body of
+// digest. It returns the response remembered for key if a request with it
+// already finished, or an error if one is still running or its body differs.
+func (bingo *Bingo) ReserveResponse(key string, digest string) (*Response, error) {
+	idempotency := bingo.idempotency
+	idempotency.mutex.Lock()
+	defer idempotency.mutex.Unlock()
+
+	now := time.Now()
+	idempotency.expire(now)
+
+	if request, ok := idempotency.responses[key]; ok {
+		if request.digest != digest {
+			return nil, errors.New(IdempotencyKeyReused)
+		}
+		if request.response == nil {
+			return nil, errors.New(RequestInProgress)
+		}
+		return request.response, nil
+	}
+
+	idempotency.responses[key] = &idempotentRequest{digest: digest}
+	idempotency.touch(key, now)
+	return nil, nil
+}
+
+// SaveResponse remembers the response of the request which reserved key.
+func (bingo *Bingo) SaveResponse(key string, response *Response) {
+	idempotency := bingo.idempotency
+	idempotency.mutex.Lock()
+	defer idempotency.mutex.Unlock()
+
+	if request, ok := idempotency.responses[key]; ok {
+		request.response = response
+		idempotency.touch(key, time.Now())
+	}
+}
+
+// ReleaseResponse forgets key so that a retry runs the request again.
+func (bingo *Bingo) ReleaseResponse(key string) {
+	idempotency := bingo.idempotency
+	idempotency.mutex.Lock()
+	defer idempotency.mutex.Unlock()
+
+	delete(idempotency.responses, key)
+}
+
+// sweep forgets the requests whose ttl passed, also while no request comes.
+func (idempotency *Idempotency) sweep() {
+	idempotency.mutex.Lock()
+	defer idempotency.mutex.Unlock()
+
+	idempotency.expire(time.Now())
+}
+
+func (idempotency *Idempotency) start() {
+	idempotency.cron.Start()
+}
+
+func (idempotency *Idempotency) stop() {
+	idempotency.cron.Stop()
+}
+
+// touch keeps the request of key for ttl from now. The caller must hold the lock.
+func (idempotency *Idempotency) touch(key string, now time.Time) {
+	expireAt := now.Add(idempotency.ttl)
+	idempotency.responses[key].expireAt = expireAt
+	idempotency.expiries = append(idempotency.expiries, &idempotencyExpiry{key: key, expireAt: expireAt})
+}
+
+// expire forgets the requests whose ttl passed. A key touched again since
+// an expiry was queued is kept until its later expiry. The caller must
+// hold the lock.
+func (idempotency *Idempotency) expire(now time.Time) {
+	expired := 0
+	for _, expiry := range idempotency.expiries {
+		if expiry.expireAt.After(now) {
+			break
+		}
+		if request, ok := idempotency.responses[expiry.key]; ok && !request.expireAt.After(now) {
+			delete(idempotency.responses, expiry.key)
+		}
+		expired++
+	}
+	idempotency.expiries = idempotency.expiries[expired:]
+}
@@ -15,10 +15,84 @@ type IndexInterface interface {
 	SortKey() *FieldSchema
 }
 
+// RangeIndex is an index which also queries ranges of its partitions.
+type RangeIndex interface {
+	IndexInterface
+	Query(hash interface{}, options *ScanOptions) (values []Data, next interface{}, err error)
+	Count(hash interface{}, options *ScanOptions) (int64, error)
+	Rank(hash interface{}, sort interface{}) (int64, error)
+	QueryMerged(cursors []*PartitionCursor, options *ScanOptions) (values []Data, next []*PartitionCursor, err error)
+	Partitions(after interface{}, limit int) (partitions []*Partition, next interface{}, err error)
+}
+
+// documents returns every document of a partition of index in range.
+func documents(index IndexInterface, hash interface{}, options *ScanOptions) ([]*Document, error) {
+	switch index := index.(type) {
+	case *PrimaryIndex:
+		return index.documents(hash, options)
+	case *SubIndex:
+		return index.documents(hash, options)
+	}
+	return nil, errors.New(IndexNotFound)
+}
+
+// queryAsOf scans a partition of index as it was at version.
+func queryAsOf(index IndexInterface, hash interface{}, options *ScanOptions, version int64) ([]Data, interface{}, error) {
+	switch index := index.(type) {
+	case *PrimaryIndex:
+		return index.queryAsOf(hash, options, version)
+	case *SubIndex:
+		return index.queryAsOf(hash, options, version)
+	}
+	return make([]Data, 0), nil, errors.New(IndexNotFound)
+}
+
+// openAsOf prepares a partition of index to be scanned by queryAsOf.
+func openAsOf(index IndexInterface, hash interface{}) {
+	switch index := index.(type) {
+	case *PrimaryIndex:
+		index.openAsOf(hash)
+	case *SubIndex:
+		index.openAsOf(hash)
+	}
+}
+
+// DefaultMaxExamined is the MaxExamined of a filtered scan if not given.
+const DefaultMaxExamined = 1000
+
+// ScanOptions are the options of a scan on a partition.
+type ScanOptions struct {
+	Since          interface{}
+	Until          interface{}
+	Prefix         string
+	SinceExclusive bool
+	UntilExclusive bool
+	Limit          int
+	// Offset skips as many matching documents before the page.
+	Offset      int
+	Backward    bool
+	Filter      *Filter
+	MaxExamined int
+	// Fields selects the fields of documents to return, all if empty.
+	Fields []string
+}
+
 type index struct {
 	m *sync.Map
 	*KeySchema
 	size int64
+	// hashes holds every hash value of m in order
+	hashes *lazyskiplist.SkipList
+	// counts holds the counted keys of each partition of m
+	counts *sync.Map
+	// retired holds the documents retained for snapshots by hash and key
+	retired *sync.Map
+}
+
+// Partition is a hash value of an index with the number of its documents.
+type Partition struct {
+	Hash interface{}
+	Size int64
 }
 
 type PrimaryIndex struct {
@@ -33,7 +107,53 @@ type SubIndex struct {
 }
 
 func newIndex(keySchema *KeySchema) *index {
-	return &index{m: new(sync.Map), KeySchema: keySchema}
+	return &index{
+		m:         new(sync.Map),
+		KeySchema: keySchema,
+		hashes:    lazyskiplist.NewLazySkipList(GeneralCompare),
+		counts:    new(sync.Map),
+		retired:   new(sync.Map),
+	}
+}
+
+// partition returns the skip list and counted keys of hash, storing new ones.
+func (index *index) partition(hash interface{}, compare func(a, b interface{}) int) (*lazyskiplist.SkipList, *countedList) {
+	counts, _ := index.counts.LoadOrStore(hash, newCountedList(compare))
+	read, loaded := index.m.LoadOrStore(hash, lazyskiplist.NewLazySkipList(compare))
+	if !loaded {
+		index.hashes.Put(hash, nil, nil)
+	}
+	return read.(*lazyskiplist.SkipList), counts.(*countedList)
+}
+
+// partitions returns up to limit non-empty partitions after the hash after.
+func (index *index) partitions(afterRaw interface{}, limit int) (partitions []*Partition, next interface{}, err error) {
+	partitions = make([]*Partition, 0)
+	after := index.Collate(ParseField(index.hashKey, afterRaw))
+
+	it := index.hashes.Begin(after)
+	for ; it.Present() && len(partitions) < limit; it.Next() {
+		hash := it.Key()
+		if after != nil && GeneralCompare(hash, after) == 0 {
+			continue
+		}
+		if size := index.skipList(hash).Size(); size > 0 {
+			partitions = append(partitions, &Partition{Hash: hash, Size: size})
+		}
+	}
+
+	if it.Present() && len(partitions) > 0 {
+		next = partitions[len(partitions)-1].Hash
+	}
+	return partitions, next, nil
+}
+
+func (index *PrimaryIndex) Partitions(after interface{}, limit int) ([]*Partition, interface{}, error) {
+	return index.partitions(after, limit)
+}
+
+func (index *SubIndex) Partitions(after interface{}, limit int) ([]*Partition, interface{}, error) {
+	return index.partitions(after, limit)
 }
 
 func (index *index) skipList(hash interface{}) *lazyskiplist.SkipList {
@@ -43,6 +163,21 @@ func (index *index) skipList(hash interface{}) *lazyskiplist.SkipList {
 	return nil
 }
 
+// uncount removes key from the counted keys of hash, dropped once empty.
+func (index *index) uncount(hash, key interface{}) {
+	if counts := index.counted(hash); counts != nil && counts.remove(key) && counts.length() == 0 {
+		index.counts.Delete(hash)
+	}
+}
+
+// counted returns the counted keys of hash, nil if none.
+func (index *index) counted(hash interface{}) *countedList {
+	if read, ok := index.counts.Load(hash); ok {
+		return read.(*countedList)
+	}
+	return nil
+}
+
 func (index *PrimaryIndex) HashKey() *FieldSchema {
 	return index.hashKey
 }
@@ -60,8 +195,8 @@ func (index *SubIndex) SortKey() *FieldSchema {
 }
 
 func (index *PrimaryIndex) parseKeys(hashRaw, sortRaw interface{}) (interface{}, interface{}, error) {
-	hash := ParseField(index.hashKey, hashRaw)
-	sort := ParseField(index.sortKey, sortRaw)
+	hash := index.Collate(ParseField(index.hashKey, hashRaw))
+	sort := index.Collate(ParseField(index.sortKey, sortRaw))
 
 	if hash == nil {
 		return nil, nil, errors.New(HashKeyMissing)
@@ -75,7 +210,7 @@ func (index *PrimaryIndex) parseKeys(hashRaw, sortRaw interface{}) (interface{},
 }
 
 func (index *SubIndex) parseKeys(hashRaw, sortRaw interface{}) (interface{}, SubSortKey, error) {
-	hash := ParseField(index.hashKey, hashRaw)
+	hash := index.Collate(ParseField(index.hashKey, hashRaw))
 	sort := index.parseSubSortKey(sortRaw)
 
 	if hash == nil {
@@ -102,6 +237,16 @@ func (index *PrimaryIndex) Get(hashRaw, sortRaw interface{}) (*Document, error)
 	return nil, errors.New(DocumentNotFound)
 }
 
+// get looks up a document by parsed key values. It returns nil if not found.
+func (index *PrimaryIndex) get(hash, sort interface{}) *Document {
+	if list := index.skipList(index.Collate(hash)); list != nil {
+		if value, ok := list.Get(index.Collate(sort)); ok {
+			return value.(*Document)
+		}
+	}
+	return nil
+}
+
 func (index *PrimaryIndex) Range(f func(key interface{}, list *lazyskiplist.SkipList) bool) {
 	index.m.Range(func(key, value interface{}) bool {
 		return f(key, value.(*lazyskiplist.SkipList))
@@ -109,43 +254,160 @@ func (index *PrimaryIndex) Range(f func(key interface{}, list *lazyskiplist.Skip
 }
 
 func (index *PrimaryIndex) Scan(hashRaw, sinceRaw interface{}, limit int) (result []Data, next interface{}, err error) {
+	return index.Query(hashRaw, &ScanOptions{Since: sinceRaw, Limit: limit})
+}
+
+func (index *PrimaryIndex) RScan(hashRaw, sinceRaw interface{}, limit int) (result []Data, next interface{}, err error) {
+	return index.Query(hashRaw, &ScanOptions{Since: sinceRaw, Limit: limit, Backward: true})
+}
+
+func (index *PrimaryIndex) Query(hashRaw interface{}, options *ScanOptions) (result []Data, next interface{}, err error) {
 	result = make([]Data, 0)
-	hash := ParseField(index.hashKey, hashRaw)
-	since := ParseField(index.sortKey, sinceRaw)
+	hash := index.Collate(ParseField(index.hashKey, hashRaw))
 	if hash == nil {
 		return result, next, errors.New(HashKeyMissing)
 	}
+	if err := index.checkPrefix(options); err != nil {
+		return result, next, err
+	}
 
 	if list := index.skipList(hash); list != nil {
-		it := list.Begin(since)
-		for i := 0; i < limit && it.Present(); i, _ = i+1, it.Next() {
-			result = append(result, it.Value().(*Document).Data())
-		}
-		if it.Present() {
-			next = it.Key()
+		since, until, options := index.bounds(options)
+		it, since, options, ok := index.seek(hash, list, since, options, index.iterator, index.CompareSort)
+		if ok {
+			result, next = options.collect(it, since, until, index.CompareSort)
 		}
 	}
 	return result, next, nil
 }
 
-func (index *PrimaryIndex) RScan(hashRaw, sinceRaw interface{}, limit int) (result []Data, next interface{}, err error) {
-	result = make([]Data, 0)
-	hash := ParseField(index.hashKey, hashRaw)
-	since := ParseField(index.sortKey, sinceRaw)
+// QueryMerged scans the partitions of cursors at once in order of the sort key.
+func (index *PrimaryIndex) QueryMerged(cursors []*PartitionCursor, options *ScanOptions) ([]Data, []*PartitionCursor, error) {
+	if err := index.checkPrefix(options); err != nil {
+		return make([]Data, 0), nil, err
+	}
+	since, until, options := index.bounds(options)
+	parse := func(raw interface{}) interface{} {
+		return index.Collate(ParseField(index.sortKey, raw))
+	}
+	return index.merge(cursors, options, since, until, parse, index.iterator, index.CompareSort)
+}
+
+// queryAsOf scans a partition as it was at version.
+func (index *PrimaryIndex) queryAsOf(hashRaw interface{}, options *ScanOptions, version int64) ([]Data, interface{}, error) {
+	hash := index.Collate(ParseField(index.hashKey, hashRaw))
 	if hash == nil {
-		return result, next, errors.New(HashKeyMissing)
+		return make([]Data, 0), nil, errors.New(HashKeyMissing)
+	}
+	if err := index.checkPrefix(options); err != nil {
+		return make([]Data, 0), nil, err
 	}
 
-	if list := index.skipList(hash); list != nil {
-		it := list.End(since)
-		for i := 0; i < limit && it.Present(); i, _ = i+1, it.Prev() {
-			result = append(result, it.Value().(*Document).Data())
+	since, until, options := index.bounds(options)
+	result, next := index.mergeAsOf(hash, options, version, since, until, index.iterator, index.CompareSort)
+	return result, next, nil
+}
+
+// All reads up to limit documents of every partition from hashRaw and sortRaw.
+func (index *PrimaryIndex) All(hashRaw, sortRaw interface{}, limit int, fields []string) (result []Data, next interface{}, err error) {
+	result = make([]Data, 0)
+	hash := index.Collate(ParseField(index.hashKey, hashRaw))
+	sort := index.Collate(ParseField(index.sortKey, sortRaw))
+
+	for it := index.hashes.Begin(hash); it.Present(); it.Next() {
+		list := index.skipList(it.Key())
+		if list == nil || list.Size() == 0 {
+			continue
 		}
-		if it.Present() {
-			next = it.Key()
+
+		since := sort
+		if hash == nil || GeneralCompare(it.Key(), hash) != 0 {
+			since = nil
+		}
+		if len(result) >= limit {
+			if first := list.Begin(since); first.Present() {
+				return result, []interface{}{it.Key(), first.Key()}, nil
+			}
+			continue
+		}
+
+		options := &ScanOptions{Limit: limit - len(result), Fields: fields}
+		values, nextSort := options.collect(list.Begin(since), since, nil, index.CompareSort)
+		result = append(result, values...)
+		if nextSort != nil {
+			return result, []interface{}{it.Key(), nextSort}, nil
 		}
 	}
-	return result, next, nil
+	return result, nil, nil
+}
+
+// Rank returns the 0-based position of the document with sort in its partition.
+func (index *PrimaryIndex) Rank(hashRaw, sortRaw interface{}) (int64, error) {
+	hash, sort, err := index.parseKeys(hashRaw, sortRaw)
+	if err != nil {
+		return 0, err
+	}
+
+	if list, counts := index.skipList(hash), index.counted(hash); list != nil && counts != nil {
+		if _, ok := list.Get(sort); ok {
+			return counts.rank(sort, index.CompareSort), nil
+		}
+	}
+	return 0, errors.New(DocumentNotFound)
+}
+
+// Count returns the number of documents of a partition matching options.
+func (index *PrimaryIndex) Count(hashRaw interface{}, options *ScanOptions) (int64, error) {
+	hash := index.Collate(ParseField(index.hashKey, hashRaw))
+	if hash == nil {
+		return 0, errors.New(HashKeyMissing)
+	}
+	if err := index.checkPrefix(options); err != nil {
+		return 0, err
+	}
+
+	list, counts := index.skipList(hash), index.counted(hash)
+	if list == nil || counts == nil {
+		return 0, nil
+	}
+	since, until, options := index.bounds(options)
+	if options.Filter != nil {
+		return options.count(index.iterator(list, since, options.Backward), since, until, index.CompareSort), nil
+	}
+	return options.countRange(counts, since, until, index.CompareSort), nil
+}
+
+func (index *PrimaryIndex) bounds(options *ScanOptions) (since interface{}, until interface{}, narrowed *ScanOptions) {
+	since, until = index.Collate(ParseField(index.sortKey, options.Since)), index.Collate(ParseField(index.sortKey, options.Until))
+	if first, last := index.prefixRange(options); first != nil {
+		return options.narrow(since, until, first, last, index.CompareSort)
+	}
+	return since, until, options
+}
+
+func (index *PrimaryIndex) iterator(list *lazyskiplist.SkipList, since interface{}, backward bool) *lazyskiplist.Iterator {
+	if backward {
+		return list.End(since)
+	}
+	return list.Begin(since)
+}
+
+// documents returns every document of a partition in range.
+func (index *PrimaryIndex) documents(hashRaw interface{}, options *ScanOptions) ([]*Document, error) {
+	result := make([]*Document, 0)
+	hash := index.Collate(ParseField(index.hashKey, hashRaw))
+	if hash == nil {
+		return result, errors.New(HashKeyMissing)
+	}
+	if err := index.checkPrefix(options); err != nil {
+		return result, err
+	}
+
+	if list := index.skipList(hash); list != nil {
+		since, until, options := index.bounds(options)
+		result = options.documents(index.iterator(list, since, options.Backward), since, until, index.CompareSort)
+	}
+	return result, nil
 }
 
 func (index *SubIndex) Get(hashRaw, sortRaw interface{}) (*Document, error) {
@@ -166,43 +428,167 @@ func (index *SubIndex) Get(hashRaw, sortRaw interface{}) (*Document, error) {
 }
 
 func (index *SubIndex) Scan(hashRaw, sinceRaw interface{}, limit int) (result []Data, next interface{}, err error) {
+	return index.Query(hashRaw, &ScanOptions{Since: sinceRaw, Limit: limit})
+}
+
+func (index *SubIndex) RScan(hashRaw, sinceRaw interface{}, limit int) (result []Data, next interface{}, err error) {
+	return index.Query(hashRaw, &ScanOptions{Since: sinceRaw, Limit: limit, Backward: true})
+}
+
+func (index *SubIndex) Query(hashRaw interface{}, options *ScanOptions) (result []Data, next interface{}, err error) {
 	result = make([]Data, 0)
-	hash := ParseField(index.hashKey, hashRaw)
-	since := index.parseSubSortKey(sinceRaw)
+	hash := index.Collate(ParseField(index.hashKey, hashRaw))
 	if hash == nil {
 		return result, next, errors.New(HashKeyMissing)
 	}
+	if err := index.checkPrefix(options); err != nil {
+		return result, next, err
+	}
 
 	if list := index.skipList(hash); list != nil {
-		it := list.Begin(since)
-		for i := 0; i < limit && it.Present(); i, _ = i+1, it.Next() {
-			result = append(result, it.Value().(*Document).Data())
-		}
-		if it.Present() {
-			next = it.Key()
+		since, until, options := index.bounds(options)
+		it, since, options, ok := index.seek(hash, list, since, options, index.iterator, index.compareBound)
+		if ok {
+			result, next = options.collect(it, since, until, index.compareBound)
 		}
 	}
 	return result, next, nil
 }
 
-func (index *SubIndex) RScan(hashRaw, sinceRaw interface{}, limit int) (result []Data, next interface{}, err error) {
-	result = make([]Data, 0)
-	hash := ParseField(index.hashKey, hashRaw)
-	since := index.parseSubSortKey(sinceRaw)
+// QueryMerged scans the partitions of cursors at once in order of the sort key.
+func (index *SubIndex) QueryMerged(cursors []*PartitionCursor, options *ScanOptions) ([]Data, []*PartitionCursor, error) {
+	if err := index.checkPrefix(options); err != nil {
+		return make([]Data, 0), nil, err
+	}
+	since, until, options := index.bounds(options)
+	parse := func(raw interface{}) interface{} {
+		if key := index.parseSubSortKey(raw); key != (SubSortKey{}) {
+			return key
+		}
+		return nil
+	}
+	return index.merge(cursors, options, since, until, parse, index.iterator, index.compareBound)
+}
+
+// queryAsOf scans a partition as it was at version.
+func (index *SubIndex) queryAsOf(hashRaw interface{}, options *ScanOptions, version int64) ([]Data, interface{}, error) {
+	hash := index.Collate(ParseField(index.hashKey, hashRaw))
 	if hash == nil {
-		return result, next, errors.New(HashKeyMissing)
+		return make([]Data, 0), nil, errors.New(HashKeyMissing)
+	}
+	if err := index.checkPrefix(options); err != nil {
+		return make([]Data, 0), nil, err
 	}
 
-	if list := index.skipList(hash); list != nil {
-		it := list.End(since)
-		for i := 0; i < limit && it.Present(); i, _ = i+1, it.Prev() {
-			result = append(result, it.Value().(*Document).Data())
+	since, until, options := index.bounds(options)
+	result, next := index.mergeAsOf(hash, options, version, since, until, index.iterator, index.compareBound)
+	return result, next, nil
+}
+
+// Rank returns the 0-based position of the first document with sort in its partition.
+func (index *SubIndex) Rank(hashRaw, sortRaw interface{}) (int64, error) {
+	hash, sort, err := index.parseKeys(hashRaw, sortRaw)
+	if err != nil {
+		return 0, err
+	}
+
+	if list, counts := index.skipList(hash), index.counted(hash); list != nil && counts != nil {
+		if key, _, ok := list.Ceiling(sort); ok && index.compareBound(key, sort) == 0 {
+			return counts.rank(sort, index.compareBound), nil
 		}
-		if it.Present() {
-			next = it.Key()
+	}
+	return 0, errors.New(DocumentNotFound)
+}
+
+// Count returns the number of documents of a partition matching options.
+func (index *SubIndex) Count(hashRaw interface{}, options *ScanOptions) (int64, error) {
+	hash := index.Collate(ParseField(index.hashKey, hashRaw))
+	if hash == nil {
+		return 0, errors.New(HashKeyMissing)
+	}
+	if err := index.checkPrefix(options); err != nil {
+		return 0, err
+	}
+
+	list, counts := index.skipList(hash), index.counted(hash)
+	if list == nil || counts == nil {
+		return 0, nil
+	}
+	since, until, options := index.bounds(options)
+	if options.Filter != nil {
+		return options.count(index.iterator(list, since, options.Backward), since, until, index.compareBound), nil
+	}
+	return options.countRange(counts, since, until, index.compareBound), nil
+}
+
+// bounds parses since and until of options, narrowed to its prefix.
+func (index *SubIndex) bounds(options *ScanOptions) (since interface{}, until interface{}, narrowed *ScanOptions) {
+	if key := index.parseSubSortKey(options.Since); key != (SubSortKey{}) {
+		since = key
+	}
+	if key := index.parseSubSortKey(options.Until); key != (SubSortKey{}) {
+		until = key
+	}
+	if first, last := index.prefixRange(options); first != nil {
+		return options.narrow(since, until, SubSortKey{sort: first}, SubSortKey{sort: last}, index.compareBound)
+	}
+	return since, until, options
+}
+
+// iterator positions a scan at since, partial or not.
+func (index *SubIndex) iterator(list *lazyskiplist.SkipList, since interface{}, backward bool) *lazyskiplist.Iterator {
+	if since == nil {
+		if backward {
+			return list.End(nil)
 		}
+		return list.Begin(SubSortKey{})
 	}
-	return result, next, nil
+	if !backward {
+		return list.Begin(since)
+	}
+	if key := since.(SubSortKey); key.primaryHash != nil && key.primarySort != nil {
+		return list.End(since)
+	}
+
+	it := list.Begin(since)
+	for it.Present() && index.compareBound(it.Key(), since) == 0 {
+		it.Next()
+	}
+	if !it.Present() {
+		return list.End(nil)
+	}
+	it.Prev()
+	return it
+}
+
+// documents returns every document of a partition in range.
+func (index *SubIndex) documents(hashRaw interface{}, options *ScanOptions) ([]*Document, error) {
+	result := make([]*Document, 0)
+	hash := index.Collate(ParseField(index.hashKey, hashRaw))
+	if hash == nil {
+		return result, errors.New(HashKeyMissing)
+	}
+	if err := index.checkPrefix(options); err != nil {
+		return result, err
+	}
+
+	if list := index.skipList(hash); list != nil {
+		since, until, options := index.bounds(options)
+		result = options.documents(index.iterator(list, since, options.Backward), since, until, index.compareBound)
+	}
+	return result, nil
+}
+
+// compareBound compares a key with a bound, which may be partial.
+func (index *SubIndex) compareBound(keyRaw interface{}, boundRaw interface{}) int {
+	key, bound := keyRaw.(SubSortKey), boundRaw.(SubSortKey)
+	if diff := index.CompareSort(key.sort, bound.sort); diff != 0 || bound.primaryHash == nil {
+		return diff
+	}
+	if diff := GeneralCompare(key.primaryHash, bound.primaryHash); diff != 0 || bound.primarySort == nil {
+		return diff
+	}
+	return GeneralCompare(key.primarySort, bound.primarySort)
 }
 
 func (index *SubIndex) parseSubSortKey(raw interface{}) SubSortKey {
@@ -218,7 +604,7 @@ func (index *SubIndex) parseSubSortKey(raw interface{}) SubSortKey {
 		key := SubSortKey{}
 		ary := raw.([]interface{})
 		if len(ary) > 0 {
-			key.sort = ParseField(index.sortKey, ary[0])
+			key.sort = index.Collate(ParseField(index.sortKey, ary[0]))
 		}
 		if len(ary) > 1 {
 			key.primaryHash = ParseField(index.primaryKeySchema.hashKey, ary[1])
@@ -229,8 +615,391 @@ func (index *SubIndex) parseSubSortKey(raw interface{}) SubSortKey {
 		return key
 
 	default:
-		return SubSortKey{sort: ParseField(index.sortKey, raw)}
+		return SubSortKey{sort: index.Collate(ParseField(index.sortKey, raw))}
+	}
+}
+
+// collect reads a page from it and returns the key to continue from.
+func (options *ScanOptions) collect(it *lazyskiplist.Iterator, since, until interface{}, compare func(key, bound interface{}) int) (result []Data, next interface{}) {
+	result = make([]Data, 0)
+	maxExamined := options.MaxExamined
+	if maxExamined <= 0 {
+		maxExamined = DefaultMaxExamined
+	}
+
+	examined, skipped := 0, 0
+	next = options.walk(it, since, until, compare, func(doc *Document) bool {
+		if skipped < options.Offset {
+			if options.Filter.Match(doc) {
+				skipped++
+			}
+			return true
+		}
+		if len(result) >= options.Limit || (options.Filter != nil && examined >= maxExamined) {
+			return false
+		}
+		examined++
+		if options.Filter.Match(doc) {
+			result = append(result, doc.Select(options.Fields...))
+		}
+		return true
+	})
+	return result, next
+}
+
+// documents returns every document from it in the range matching the filter.
+func (options *ScanOptions) documents(it *lazyskiplist.Iterator, since, until interface{}, compare func(key, bound interface{}) int) []*Document {
+	result := make([]*Document, 0)
+	options.walk(it, since, until, compare, func(doc *Document) bool {
+		if options.Filter.Match(doc) {
+			result = append(result, doc)
+		}
+		return true
+	})
+	return result
+}
+
+func (options *ScanOptions) count(it *lazyskiplist.Iterator, since, until interface{}, compare func(key, bound interface{}) int) int64 {
+	var count int64
+	options.walk(it, since, until, compare, func(doc *Document) bool {
+		if options.Filter.Match(doc) {
+			count++
+		}
+		return true
+	})
+	return count
+}
+
+// countRange counts the keys from since to until by their positions.
+func (options *ScanOptions) countRange(counts *countedList, since, until interface{}, compare func(key, bound interface{}) int) int64 {
+	first, last := since, until
+	firstExclusive, lastExclusive := options.SinceExclusive, options.UntilExclusive
+	if options.Backward {
+		first, last = until, since
+		firstExclusive, lastExclusive = lastExclusive, firstExclusive
+	}
+
+	var before int64
+	if first != nil && firstExclusive {
+		before = counts.rankThrough(first, compare)
+	} else if first != nil {
+		before = counts.rank(first, compare)
+	}
+
+	through := counts.length()
+	if last != nil && lastExclusive {
+		through = counts.rank(last, compare)
+	} else if last != nil {
+		through = counts.rankThrough(last, compare)
+	}
+
+	if through < before {
+		return 0
+	}
+	return through - before
+}
+
+// walk visits documents from it up to until, and returns the first not visited.
+func (options *ScanOptions) walk(it *lazyskiplist.Iterator, since, until interface{}, compare func(key, bound interface{}) int, visit func(doc *Document) bool) interface{} {
+	advance := it.Next
+	if options.Backward {
+		advance = it.Prev
+	}
+
+	if options.SinceExclusive && since != nil {
+		for it.Present() && compare(it.Key(), since) == 0 {
+			advance()
+		}
+	}
+
+	for ; it.Present(); advance() {
+		if !options.within(it.Key(), until, compare) {
+			return nil
+		}
+		if !visit(it.Value().(*Document)) {
+			return it.Key()
+		}
+	}
+	return nil
+}
+
+// within reports whether key has not passed until in the scan direction.
+func (options *ScanOptions) within(key, until interface{}, compare func(key, bound interface{}) int) bool {
+	if until == nil {
+		return true
+	}
+	diff := compare(key, until)
+	if options.Backward {
+		diff = -diff
+	}
+	return diff < 0 || (diff == 0 && !options.UntilExclusive)
+}
+
+// PartitionCursor is where a partition of a merged scan continues from.
+type PartitionCursor struct {
+	Hash  interface{}
+	Since interface{}
+}
+
+// mergeSource is a partition of a merged scan positioned at its next document.
+type mergeSource struct {
+	hash interface{}
+	it   *lazyskiplist.Iterator
+	// pinned skips documents not current at version asOf
+	pinned bool
+	asOf   int64
+}
+
+// document returns the document at the source, the one current at asOf if retained.
+func (source *mergeSource) document() *Document {
+	switch value := source.it.Value().(type) {
+	case *Document:
+		return value
+	case []*retiredDocument:
+		for _, retired := range value {
+			if retired.currentAt(source.asOf) {
+				return retired.doc
+			}
+		}
+	}
+	return nil
+}
+
+// visible reports whether the document at the position of the source is read.
+func (source *mergeSource) visible() bool {
+	doc := source.document()
+	return doc != nil && (!source.pinned || doc.version <= source.asOf)
+}
+
+// merge reads a page from the partitions of cursors, ties broken by hash.
+func (index *index) merge(cursors []*PartitionCursor, options *ScanOptions, since, until interface{},
+	parse func(raw interface{}) interface{},
+	iterator func(list *lazyskiplist.SkipList, since interface{}, backward bool) *lazyskiplist.Iterator,
+	compare func(key, bound interface{}) int) (result []Data, next []*PartitionCursor, err error) {
+	result = make([]Data, 0)
+	sources := make([]*mergeSource, 0, len(cursors))
+	seen := make(map[interface{}]bool)
+	for _, cursor := range cursors {
+		hash := index.Collate(ParseField(index.hashKey, cursor.Hash))
+		if hash == nil {
+			return result, nil, errors.New(HashKeyMissing)
+		}
+		if seen[hash] {
+			continue
+		}
+		seen[hash] = true
+
+		list := index.skipList(hash)
+		if list == nil {
+			continue
+		}
+		source := &mergeSource{hash: hash}
+		if start := parse(cursor.Since); start != nil {
+			source.it = iterator(list, start, options.Backward)
+		} else {
+			source.it = options.start(list, since, iterator, compare)
+		}
+		sources = append(sources, source)
+	}
+
+	result, sources = options.mergeSources(sources, until, compare)
+	for _, source := range sources {
+		next = append(next, &PartitionCursor{Hash: source.hash, Since: source.it.Key()})
+	}
+	return result, next, nil
+}
+
+// start positions a scan of list at since, past since itself if exclusive.
+func (options *ScanOptions) start(list *lazyskiplist.SkipList, since interface{},
+	iterator func(list *lazyskiplist.SkipList, since interface{}, backward bool) *lazyskiplist.Iterator,
+	compare func(key, bound interface{}) int) *lazyskiplist.Iterator {
+	it := iterator(list, since, options.Backward)
+	if options.SinceExclusive && since != nil {
+		for it.Present() && compare(it.Key(), since) == 0 {
+			options.advance(it)
+		}
+	}
+	return it
+}
+
+// mergeSources reads a page from sources and returns those not exhausted.
+func (options *ScanOptions) mergeSources(sources []*mergeSource, until interface{}, compare func(key, bound interface{}) int) (result []Data, remaining []*mergeSource) {
+	result = make([]Data, 0)
+	maxExamined := options.MaxExamined
+	if maxExamined <= 0 {
+		maxExamined = DefaultMaxExamined
+	}
+
+	examined, skipped := 0, 0
+	for {
+		var first *mergeSource
+		remaining = sources[:0]
+		for _, source := range sources {
+			for source.it.Present() && !source.visible() {
+				options.advance(source.it)
+			}
+			if !source.it.Present() || !options.within(source.it.Key(), until, compare) {
+				continue
+			}
+			remaining = append(remaining, source)
+			if first == nil || options.precedes(source, first, compare) {
+				first = source
+			}
+		}
+		sources = remaining
+
+		if first == nil {
+			break
+		}
+		if doc := first.document(); skipped < options.Offset {
+			if options.Filter.Match(doc) {
+				skipped++
+			}
+			options.skip(sources, first, compare)
+			continue
+		}
+
+		if len(result) >= options.Limit || (options.Filter != nil && examined >= maxExamined) {
+			break
+		}
+		examined++
+		if doc := first.document(); options.Filter.Match(doc) {
+			result = append(result, doc.Select(options.Fields...))
+		}
+		options.skip(sources, first, compare)
+	}
+	return result, remaining
+}
+
+// skip advances first and the sources of its partition past its key.
+func (options *ScanOptions) skip(sources []*mergeSource, first *mergeSource, compare func(key, bound interface{}) int) {
+	key := first.it.Key()
+	for _, source := range sources {
+		if source != first && source.it.Present() && GeneralCompare(source.hash, first.hash) == 0 && compare(source.it.Key(), key) == 0 {
+			options.advance(source.it)
+		}
+	}
+	options.advance(first.it)
+}
+
+// mergeAsOf reads a page of a partition and its retained documents as of version.
+func (index *index) mergeAsOf(hash interface{}, options *ScanOptions, version int64, since, until interface{},
+	iterator func(list *lazyskiplist.SkipList, since interface{}, backward bool) *lazyskiplist.Iterator,
+	compare func(key, bound interface{}) int) (result []Data, next interface{}) {
+	sources := make([]*mergeSource, 0, 2)
+	for _, list := range []*lazyskiplist.SkipList{index.skipList(hash), index.retiredList(hash)} {
+		if list != nil {
+			sources = append(sources, &mergeSource{hash: hash, it: options.start(list, since, iterator, compare), pinned: true, asOf: version})
+		}
+	}
+
+	result, sources = options.mergeSources(sources, until, compare)
+	var first *mergeSource
+	for _, source := range sources {
+		if first == nil || options.precedes(source, first, compare) {
+			first = source
+		}
+	}
+	if first != nil {
+		next = first.it.Key()
+	}
+	return result, next
+}
+
+// precedes reports whether the next document of a comes before that of b.
+func (options *ScanOptions) precedes(a, b *mergeSource, compare func(key, bound interface{}) int) bool {
+	diff := compare(a.it.Key(), b.it.Key())
+	if diff == 0 {
+		diff = GeneralCompare(a.hash, b.hash)
+	}
+	if options.Backward {
+		diff = -diff
+	}
+	return diff < 0
+}
+
+func (options *ScanOptions) advance(it *lazyskiplist.Iterator) {
+	if options.Backward {
+		it.Prev()
+	} else {
+		it.Next()
+	}
+}
+
+// seek positions a scan at since, past the offset by position if unfiltered.
+func (index *index) seek(hash interface{}, list *lazyskiplist.SkipList, since interface{}, options *ScanOptions,
+	iterator func(list *lazyskiplist.SkipList, since interface{}, backward bool) *lazyskiplist.Iterator,
+	compare func(key, bound interface{}) int) (*lazyskiplist.Iterator, interface{}, *ScanOptions, bool) {
+	it := iterator(list, since, options.Backward)
+	counts := index.counted(hash)
+	if options.Offset <= 0 || options.Filter != nil || counts == nil {
+		return it, since, options, true
+	}
+
+	if options.SinceExclusive && since != nil {
+		for it.Present() && compare(it.Key(), since) == 0 {
+			options.advance(it)
+		}
+	}
+	if !it.Present() {
+		return it, since, options, false
+	}
+
+	position := counts.rank(it.Key(), compare)
+	if options.Backward {
+		position -= int64(options.Offset)
+	} else {
+		position += int64(options.Offset)
+	}
+	key, ok := counts.at(position)
+	if !ok {
+		return it, since, options, false
+	}
+
+	seeked := *options
+	seeked.Offset, seeked.SinceExclusive = 0, false
+	return iterator(list, key, options.Backward), key, &seeked, true
+}
+
+// narrow intersects since and until with the prefix range from first to last.
+func (options *ScanOptions) narrow(since, until, first, last interface{}, compare func(key, bound interface{}) int) (interface{}, interface{}, *ScanOptions) {
+	direction := 1
+	if options.Backward {
+		direction = -1
+	}
+
+	narrowed := *options
+	if since == nil || compare(since, first)*direction < 0 {
+		since, narrowed.SinceExclusive = first, false
+	}
+	if until == nil || compare(until, last)*direction > 0 {
+		until, narrowed.UntilExclusive = last, false
+	}
+	return since, until, &narrowed
+}
+
+// prefixEnd follows every character, to bound the strings with a prefix.
+const prefixEnd = "\U0010FFFF"
+
+// checkPrefix fails when options have a prefix but the sort key is not a string.
+func (index *index) checkPrefix(options *ScanOptions) error {
+	if len(options.Prefix) > 0 && (index.sortKey == nil || index.sortKey.Type != "string") {
+		return errors.New(PrefixNotString)
+	}
+	return nil
+}
+
+// prefixRange returns the bounds of the prefix of options in scan direction.
+func (index *index) prefixRange(options *ScanOptions) (first interface{}, last interface{}) {
+	if len(options.Prefix) == 0 || index.checkPrefix(options) != nil {
+		return nil, nil
+	}
+	prefix := index.Collate(options.Prefix).(string)
+	first, last = prefix, prefix+prefixEnd
+	if index.descending != options.Backward {
+		first, last = last, first
 	}
+	return first, last
 }
 
 func (index *index) sortValueFromDoc(doc *Document) interface{} {
@@ -241,40 +1010,32 @@ func (index *index) sortValueFromDoc(doc *Document) interface{} {
 }
 
 func (index *PrimaryIndex) put(doc *Document, onUpdate lazyskiplist.OnUpdate) (*Document, *Document, bool) {
-	hashValue := doc.Get(index.hashKey)
-	sortValue := doc.Get(index.sortKey)
+	hashValue := index.Collate(doc.Get(index.hashKey))
+	sortValue := index.Collate(doc.Get(index.sortKey))
 
-	newSkipList := lazyskiplist.NewLazySkipList(GeneralCompare)
-	read, _ := index.m.LoadOrStore(hashValue, newSkipList)
-	list := read.(*lazyskiplist.SkipList)
+	list, counts := index.partition(hashValue, index.CompareSort)
 
 	if old, newbie, replaced := list.Put(sortValue, doc, onUpdate); replaced {
 		return old.(*Document), newbie.(*Document), true
 	} else {
+		counts.insert(sortValue)
 		atomic.AddInt64(&index.size, 1)
 		return nil, newbie.(*Document), false
 	}
 }
 
 func (index *SubIndex) put(doc *Document) {
-	hash := doc.Get(index.hashKey)
+	if !index.covers(doc) {
+		return
+	}
+
+	hash := index.Collate(doc.Get(index.hashKey))
 	sort := index.makeSubSortKey(doc)
 
-	newSkipList := lazyskiplist.NewLazySkipList(func(a, b interface{}) int {
-		ka := a.(SubSortKey)
-		kb := b.(SubSortKey)
-		if res := GeneralCompare(ka.sort, kb.sort); res != 0 {
-			return res
-		}
-		if res := GeneralCompare(ka.primaryHash, kb.primaryHash); res != 0 {
-			return res
-		}
-		return GeneralCompare(ka.primarySort, kb.primarySort)
-	})
-	read, _ := index.m.LoadOrStore(hash, newSkipList)
-	list := read.(*lazyskiplist.SkipList)
+	list, counts := index.partition(hash, index.compareKey)
 
 	if _, _, replaced := list.Put(sort, doc, nil); !replaced {
+		counts.insert(sort)
 		atomic.AddInt64(&index.size, 1)
 	}
 }
@@ -287,6 +1048,7 @@ func (index *PrimaryIndex) remove(hashRaw, sortRaw interface{}) (*Document, erro
 
 	if list := index.skipList(hash); list != nil {
 		if value, ok := list.Remove(sort); ok {
+			index.uncount(hash, sort)
 			atomic.AddInt64(&index.size, -1)
 			return value.(*Document), nil
 		}
@@ -295,20 +1057,141 @@ func (index *PrimaryIndex) remove(hashRaw, sortRaw interface{}) (*Document, erro
 }
 
 func (index *SubIndex) remove(doc *Document) {
-	hash := doc.Get(index.hashKey)
+	if !index.covers(doc) {
+		return
+	}
+
+	hash := index.Collate(doc.Get(index.hashKey))
 	sort := index.makeSubSortKey(doc)
 
-	read, _ := index.m.Load(hash)
-	list := read.(*lazyskiplist.SkipList)
+	if list := index.skipList(hash); list != nil {
+		if _, ok := list.Remove(sort); ok {
+			index.uncount(hash, sort)
+			atomic.AddInt64(&index.size, -1)
+		}
+	}
+}
+
+// openAsOf stores the retained list of hash for a scan as of a version.
+func (index *PrimaryIndex) openAsOf(hashRaw interface{}) {
+	if hash := index.Collate(ParseField(index.hashKey, hashRaw)); hash != nil {
+		index.retiredPartition(hash, index.CompareSort)
+	}
+}
+
+// openAsOf stores the retained list of hash for a scan as of a version.
+func (index *SubIndex) openAsOf(hashRaw interface{}) {
+	if hash := index.Collate(ParseField(index.hashKey, hashRaw)); hash != nil {
+		index.retiredPartition(hash, index.compareKey)
+	}
+}
+
+// retain keeps a retired document for snapshots.
+func (index *PrimaryIndex) retain(retired *retiredDocument) {
+	hash := index.Collate(retired.doc.Get(index.hashKey))
+	index.retainKey(hash, index.Collate(retired.doc.Get(index.sortKey)), retired, index.CompareSort)
+}
+
+// release drops a retired document kept by retain.
+func (index *PrimaryIndex) release(retired *retiredDocument) {
+	hash := index.Collate(retired.doc.Get(index.hashKey))
+	index.releaseKey(hash, index.Collate(retired.doc.Get(index.sortKey)), retired)
+}
+
+// retain keeps a retired document for snapshots if it was indexed.
+func (index *SubIndex) retain(retired *retiredDocument) {
+	if index.covers(retired.doc) {
+		index.retainKey(index.Collate(retired.doc.Get(index.hashKey)), index.makeSubSortKey(retired.doc), retired, index.compareKey)
+	}
+}
+
+// release drops a retired document kept by retain.
+func (index *SubIndex) release(retired *retiredDocument) {
+	if index.covers(retired.doc) {
+		index.releaseKey(index.Collate(retired.doc.Get(index.hashKey)), index.makeSubSortKey(retired.doc), retired)
+	}
+}
+
+// retiredList returns the retained list of hash, nil if none.
+func (index *index) retiredList(hash interface{}) *lazyskiplist.SkipList {
+	if read, ok := index.retired.Load(hash); ok {
+		return read.(*lazyskiplist.SkipList)
+	}
+	return nil
+}
 
-	if _, ok := list.Remove(sort); ok {
-		atomic.AddInt64(&index.size, -1)
+// retiredPartition returns the retained list of hash, storing a new one.
+func (index *index) retiredPartition(hash interface{}, compare func(a, b interface{}) int) *lazyskiplist.SkipList {
+	read, _ := index.retired.LoadOrStore(hash, lazyskiplist.NewLazySkipList(compare))
+	return read.(*lazyskiplist.SkipList)
+}
+
+// dropRetired drops every retained list, once no snapshot is open.
+func (index *index) dropRetired() {
+	index.retired.Range(func(hash, _ interface{}) bool {
+		index.retired.Delete(hash)
+		return true
+	})
+}
+
+// retainKey appends retired to a copy of the documents retained at key.
+func (index *index) retainKey(hash, key interface{}, retired *retiredDocument, compare func(a, b interface{}) int) {
+	index.retiredPartition(hash, compare).Put(key, []*retiredDocument{retired}, func(old interface{}) interface{} {
+		docs := old.([]*retiredDocument)
+		return append(docs[:len(docs):len(docs)], retired)
+	})
+}
+
+// releaseKey removes retired from the documents retained at key.
+func (index *index) releaseKey(hash, key interface{}, retired *retiredDocument) {
+	list := index.retiredList(hash)
+	if list == nil {
+		return
+	}
+	value, ok := list.Get(key)
+	if !ok {
+		return
+	}
+
+	docs := make([]*retiredDocument, 0)
+	for _, doc := range value.([]*retiredDocument) {
+		if doc != retired {
+			docs = append(docs, doc)
+		}
+	}
+	if len(docs) == 0 {
+		list.Remove(key)
+	} else {
+		list.Put(key, docs, func(interface{}) interface{} {
+			return docs
+		})
+	}
+}
+
+// covers reports whether doc has the key fields of the sub index.
+func (index *SubIndex) covers(doc *Document) bool {
+	if doc.Get(index.hashKey) == nil {
+		return false
+	}
+	return index.sortKey == nil || doc.Get(index.sortKey) != nil
+}
+
+// compareKey orders the keys of the skip lists of the sub index.
+func (index *SubIndex) compareKey(a, b interface{}) int {
+	ka := a.(SubSortKey)
+	kb := b.(SubSortKey)
+	if res := index.CompareSort(ka.sort, kb.sort); res != 0 {
+		return res
+	}
+	if res := GeneralCompare(ka.primaryHash, kb.primaryHash); res != 0 {
+		return res
 	}
+	return GeneralCompare(ka.primarySort, kb.primarySort)
 }
 
 func (index *SubIndex) makeSubSortKey(doc *Document) SubSortKey {
 	return SubSortKey{
-		sort:        doc.Get(index.sortKey),
+		sort:        index.Collate(doc.Get(index.sortKey)),
 		primarySort: doc.Get(index.primaryKeySchema.sortKey),
 		primaryHash: doc.Get(index.primaryKeySchema.hashKey),
 	}
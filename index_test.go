@@ -4,7 +4,9 @@ package bingodb
 
 import (
 	"encoding/json"
+	"fmt"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -163,6 +165,31 @@ func TestRScanForPrimaryIndex(t *testing.T) {
 	}
 }
 
+func TestRScanForSubIndex(t *testing.T) {
+	prepare()
+
+	table := bingo.tables["onlines"]
+
+	result, next, _ := table.Index("guest").RScan("1", nil, 2)
+	if actualValue, expectedValue := len(result), 2; actualValue != expectedValue {
+		t.Errorf("size different. Got %v expected %v", actualValue, expectedValue)
+	}
+	if actualValue, expectedValue := result[0]["updatedAt"], int64(1500000000004); actualValue != expectedValue {
+		t.Errorf("Value different. Got %v expected %v", actualValue, expectedValue)
+	}
+	if actualValue, expectedValue := next.(SubSortKey).sort, int64(1500000000002); actualValue != expectedValue {
+		t.Errorf("Value different. Got %v expected %v", actualValue, expectedValue)
+	}
+
+	result, next, _ = table.Index("guest").RScan("1", int64(1500000000003), 10)
+	if actualValue, expectedValue := len(result), 3; actualValue != expectedValue {
+		t.Errorf("size different. Got %v expected %v", actualValue, expectedValue)
+	}
+	if next != nil {
+		t.Errorf("Value different. Got %v expected nil", next)
+	}
+}
+
 func TestFetchSubIndex(t *testing.T) {
 	prepare()
 
@@ -221,6 +248,96 @@ func TestFetchSubIndex(t *testing.T) {
 	//}
 }
 
+func TestOrderAndCollation(t *testing.T) {
+	configString := `
+tables:
+  sockets:
+    fields:
+      id: 'string'
+      personKey: 'string'
+      updatedAt: 'integer'
+      expiresAt: 'integer'
+    expireKey: 'expiresAt'
+    hashKey: 'personKey'
+    sortKey: 'id'
+    collation: 'nocase'
+    subIndices:
+      recent:
+        hashKey: 'personKey'
+        sortKey: 'updatedAt'
+        order: 'desc'
+        collation: 'nocase'
+`
+	bingo := newBingo()
+	if err := ParseConfigString(bingo, configString); err != nil {
+		t.Fatal(err)
+	}
+	table := bingo.tables["sockets"]
+
+	for i, key := range []string{"Person1", "person1", "PERSON1"} {
+		data := Data{"personKey": key, "id": fmt.Sprintf("soc%d", i), "updatedAt": int64(i)}
+		table.Put(&data, nil)
+	}
+	data := Data{"personKey": "person1", "id": "SOC0", "updatedAt": int64(10)}
+	table.Put(&data, nil)
+
+	if actualValue, expectedValue := table.primaryIndex.size, int64(3); actualValue != expectedValue {
+		t.Errorf("size different. Got %v expected %v", actualValue, expectedValue)
+	}
+
+	doc, err := table.primaryIndex.Get("pErSoN1", "Soc0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if actualValue, expectedValue := doc.Fetch("updatedAt"), int64(10); actualValue != expectedValue {
+		t.Errorf("Value different. Got %v expected %v", actualValue, expectedValue)
+	}
+
+	result, next, _ := table.Index("recent").Scan("PERSON1", nil, 2)
+	if actualValue, expectedValue := len(result), 2; actualValue != expectedValue {
+		t.Errorf("size different. Got %v expected %v", actualValue, expectedValue)
+	}
+	if actualValue, expectedValue := result[0]["updatedAt"], int64(10); actualValue != expectedValue {
+		t.Errorf("Value different. Got %v expected %v", actualValue, expectedValue)
+	}
+	if actualValue, expectedValue := result[1]["updatedAt"], int64(2); actualValue != expectedValue {
+		t.Errorf("Value different. Got %v expected %v", actualValue, expectedValue)
+	}
+	if actualValue, expectedValue := next.(SubSortKey).sort, int64(1); actualValue != expectedValue {
+		t.Errorf("Value different. Got %v expected %v", actualValue, expectedValue)
+	}
+
+	result, next, _ = table.Index("recent").RScan("person1", nil, 10)
+	if actualValue, expectedValue := len(result), 3; actualValue != expectedValue {
+		t.Errorf("size different. Got %v expected %v", actualValue, expectedValue)
+	}
+	if actualValue, expectedValue := result[0]["updatedAt"], int64(1); actualValue != expectedValue {
+		t.Errorf("Value different. Got %v expected %v", actualValue, expectedValue)
+	}
+}
+
+func TestErrorForUnknownOrdering(t *testing.T) {
+	configString := `
+tables:
+  weird:
+    fields:
+      id: 'string'
+      name: 'string'
+      expiresAt: 'integer'
+    expireKey: 'expiresAt'
+    hashKey: 'name'
+    sortKey: 'id'
+    subIndices:
+      friends:
+        hashKey: 'name'
+        sortKey: 'id'
+        order: 'sideways'
+`
+	if err := ParseConfigString(newBingo(), configString); err == nil {
+		t.Fail()
+	}
+}
+
 //func TestRFetchSubIndex(t *testing.T) {
 //	prepare()
 //
@@ -278,3 +395,645 @@ func TestFetchSubIndex(t *testing.T) {
 //		t.Errorf("Value different. Got %v expected %v", actualValue, expectedValue)
 //	}
 //}
+
+func TestFilter(t *testing.T) {
+	doc := &Document{data: Data{
+		"personType": "user",
+		"updatedAt":  int64(1505200000001),
+		"lastSeen":   json.Number("12"),
+		"online":     true,
+	}}
+
+	matches := map[string]bool{
+		`personType == "user"`:                                        true,
+		`personType == "user" && updatedAt > 1505200000000`:           true,
+		`personType != "user" || lastSeen <= 12`:                      true,
+		`!(lastSeen < 12.5)`:                                          false,
+		`online == true && missing == null`:                           true,
+		`missing != null || (personType == "veil" && online == true)`: false,
+		`lastSeen >= "12"`:                                            false,
+	}
+	for expression, expected := range matches {
+		filter, err := ParseFilter(expression)
+		if err != nil {
+			t.Errorf("%s: %v", expression, err)
+			continue
+		}
+		if filter.Match(doc) != expected {
+			t.Errorf("%s: expected %v", expression, expected)
+		}
+	}
+
+	for _, expression := range []string{`personType ==`, `personType = "user"`, `(a == 1`, `a == "b`, `a < null`, `a == 1 b == 2`} {
+		if _, err := ParseFilter(expression); err == nil {
+			t.Errorf("%s: expected an error", expression)
+		}
+	}
+
+	if filter, err := ParseFilter(" "); filter != nil || err != nil || !filter.Match(doc) {
+		t.Error("empty filter must match every document")
+	}
+}
+
+func TestQueryWithSubSortKeyBounds(t *testing.T) {
+	configString := `
+tables:
+  sockets:
+    fields:
+      id: 'string'
+      channelId: 'string'
+      updatedAt: 'integer'
+      expiresAt: 'integer'
+    expireKey: 'expiresAt'
+    hashKey: 'id'
+    subIndices:
+      recent:
+        hashKey: 'channelId'
+        sortKey: 'updatedAt'
+`
+	bingo := newBingo()
+	if err := ParseConfigString(bingo, configString); err != nil {
+		t.Fatal(err)
+	}
+	table := bingo.tables["sockets"]
+
+	for i, updatedAt := range []int64{1, 2, 2, 2, 3} {
+		data := Data{"channelId": "1", "id": fmt.Sprintf("soc%d", i), "updatedAt": updatedAt}
+		table.Put(&data, nil)
+	}
+
+	index := table.Index("recent")
+	options := &ScanOptions{Since: []interface{}{"2", "soc2"}, Until: []interface{}{"2"}, Limit: 10}
+	result, next, _ := index.Query("1", options)
+	if actualValue, expectedValue := len(result), 2; actualValue != expectedValue {
+		t.Errorf("size different. Got %v expected %v", actualValue, expectedValue)
+	}
+	if next != nil {
+		t.Errorf("next must be nil at the end of range. Got %v", next)
+	}
+
+	options = &ScanOptions{Since: []interface{}{"2"}, SinceExclusive: true, Limit: 10}
+	result, _, _ = index.Query("1", options)
+	if actualValue, expectedValue := len(result), 1; actualValue != expectedValue {
+		t.Errorf("size different. Got %v expected %v", actualValue, expectedValue)
+	}
+
+	options = &ScanOptions{Since: []interface{}{"3"}, Until: []interface{}{"2", "soc2"}, UntilExclusive: true, Backward: true, Limit: 10}
+	result, _, _ = index.Query("1", options)
+	if actualValue, expectedValue := len(result), 2; actualValue != expectedValue {
+		t.Errorf("size different. Got %v expected %v", actualValue, expectedValue)
+	}
+	if actualValue, expectedValue := result[1]["id"], "soc3"; actualValue != expectedValue {
+		t.Errorf("Value different. Got %v expected %v", actualValue, expectedValue)
+	}
+}
+
+func TestQueryWithPrefix(t *testing.T) {
+	configString := `
+tables:
+  members:
+    fields:
+      channelId: 'string'
+      personKey: 'string'
+      name: 'string'
+      updatedAt: 'integer'
+      expiresAt: 'integer'
+    expireKey: 'expiresAt'
+    hashKey: 'channelId'
+    sortKey: 'personKey'
+    subIndices:
+      names:
+        hashKey: 'channelId'
+        sortKey: 'name'
+        order: 'desc'
+      recent:
+        hashKey: 'channelId'
+        sortKey: 'updatedAt'
+`
+	bingo := newBingo()
+	if err := ParseConfigString(bingo, configString); err != nil {
+		t.Fatal(err)
+	}
+	table := bingo.tables["members"]
+
+	for i, key := range []string{"user", "user-1", "user-2", "user-3", "users", "veil-1"} {
+		data := Data{"channelId": "1", "personKey": key, "name": key, "updatedAt": int64(i)}
+		table.Put(&data, nil)
+	}
+
+	index := table.Index("")
+	result, next, _ := index.Query("1", &ScanOptions{Prefix: "user-", Limit: 2})
+	if actualValue, expectedValue := len(result), 2; actualValue != expectedValue {
+		t.Errorf("size different. Got %v expected %v", actualValue, expectedValue)
+	}
+	if actualValue, expectedValue := next, "user-3"; actualValue != expectedValue {
+		t.Errorf("next different. Got %v expected %v", actualValue, expectedValue)
+	}
+	result, next, _ = index.Query("1", &ScanOptions{Prefix: "user-", Since: next, Limit: 2})
+	if actualValue, expectedValue := len(result), 1; actualValue != expectedValue {
+		t.Errorf("size different. Got %v expected %v", actualValue, expectedValue)
+	}
+	if next != nil {
+		t.Errorf("next must be nil at the end of prefix. Got %v", next)
+	}
+
+	result, _, _ = index.Query("1", &ScanOptions{Prefix: "user-", Backward: true, Limit: 10})
+	if actualValue, expectedValue := result[0]["personKey"], "user-3"; actualValue != expectedValue {
+		t.Errorf("first different. Got %v expected %v", actualValue, expectedValue)
+	}
+	if actualValue, expectedValue := len(result), 3; actualValue != expectedValue {
+		t.Errorf("size different. Got %v expected %v", actualValue, expectedValue)
+	}
+
+	names := table.Index("names")
+	result, _, _ = names.Query("1", &ScanOptions{Prefix: "user", Limit: 10})
+	if actualValue, expectedValue := len(result), 5; actualValue != expectedValue {
+		t.Errorf("size different. Got %v expected %v", actualValue, expectedValue)
+	}
+	if actualValue, expectedValue := result[0]["name"], "users"; actualValue != expectedValue {
+		t.Errorf("first different. Got %v expected %v", actualValue, expectedValue)
+	}
+	if count, _ := names.Count("1", &ScanOptions{Prefix: "user-", Backward: true}); count != 3 {
+		t.Errorf("count different. Got %v expected %v", count, 3)
+	}
+
+	if _, err := table.Index("recent").Count("1", &ScanOptions{Prefix: "1"}); err == nil {
+		t.Error("prefix on an integer sort key must fail")
+	}
+}
+
+func TestQueryWithPrefixAndBounds(t *testing.T) {
+	configString := `
+tables:
+  members:
+    fields:
+      channelId: 'string'
+      personKey: 'string'
+      name: 'string'
+      expiresAt: 'integer'
+    expireKey: 'expiresAt'
+    hashKey: 'channelId'
+    sortKey: 'personKey'
+    subIndices:
+      labels:
+        hashKey: 'channelId'
+        sortKey: 'name'
+      names:
+        hashKey: 'channelId'
+        sortKey: 'name'
+        order: 'desc'
+`
+	bingo := newBingo()
+	if err := ParseConfigString(bingo, configString); err != nil {
+		t.Fatal(err)
+	}
+	table := bingo.tables["members"]
+
+	for _, key := range []string{"guest1", "user-1", "user-2", "user-3", "zeta"} {
+		data := Data{"channelId": "1", "personKey": key, "name": key}
+		table.Put(&data, nil)
+	}
+
+	// Bounds outside the prefix leave the range of the prefix as it is
+	cases := []struct {
+		index    string
+		options  ScanOptions
+		expected []string
+	}{
+		{"", ScanOptions{Since: "b"}, []string{"user-1", "user-2", "user-3"}},
+		{"", ScanOptions{Until: "zz"}, []string{"user-1", "user-2", "user-3"}},
+		{"", ScanOptions{Since: "b", SinceExclusive: true, Until: "zz", UntilExclusive: true}, []string{"user-1", "user-2", "user-3"}},
+		{"", ScanOptions{Since: "user-1", SinceExclusive: true, Until: "zz"}, []string{"user-2", "user-3"}},
+		{"", ScanOptions{Since: "zz", Backward: true}, []string{"user-3", "user-2", "user-1"}},
+		{"", ScanOptions{Until: "a", Backward: true}, []string{"user-3", "user-2", "user-1"}},
+		{"", ScanOptions{Until: "a"}, []string{}},
+		{"labels", ScanOptions{Since: []interface{}{"b"}}, []string{"user-1", "user-2", "user-3"}},
+		{"labels", ScanOptions{Until: []interface{}{"zz"}}, []string{"user-1", "user-2", "user-3"}},
+		{"labels", ScanOptions{Since: []interface{}{"user-2"}, Until: []interface{}{"zz"}}, []string{"user-2", "user-3"}},
+		{"labels", ScanOptions{Since: []interface{}{"zz"}, Backward: true}, []string{"user-3", "user-2", "user-1"}},
+		{"names", ScanOptions{Since: []interface{}{"zz"}}, []string{"user-3", "user-2", "user-1"}},
+		{"names", ScanOptions{Until: []interface{}{"a"}}, []string{"user-3", "user-2", "user-1"}},
+		{"names", ScanOptions{Since: []interface{}{"a"}, Backward: true}, []string{"user-1", "user-2", "user-3"}},
+		{"names", ScanOptions{Until: []interface{}{"zz"}, Backward: true}, []string{"user-1", "user-2", "user-3"}},
+	}
+	for _, c := range cases {
+		options := c.options
+		options.Prefix, options.Limit = "user-", 10
+		result, _, _ := table.Index(c.index).Query("1", &options)
+		actualValue := make([]string, 0)
+		for _, doc := range result {
+			actualValue = append(actualValue, doc["personKey"].(string))
+		}
+		if !reflect.DeepEqual(actualValue, c.expected) {
+			t.Errorf("documents different on %q with %+v. Got %v expected %v", c.index, c.options, actualValue, c.expected)
+		}
+		if count, _ := table.Index(c.index).Count("1", &options); count != int64(len(c.expected)) {
+			t.Errorf("count different on %q with %+v. Got %v expected %v", c.index, c.options, count, len(c.expected))
+		}
+	}
+
+	if removed, _ := table.RemoveRange("", "1", &ScanOptions{Prefix: "user-", Since: "b", Until: "zz"}); removed != 3 {
+		t.Errorf("removed different. Got %v expected %v", removed, 3)
+	}
+	if count, _ := table.Index("").Count("1", &ScanOptions{}); count != 2 {
+		t.Errorf("documents outside the prefix must be kept. Got %v left, expected %v", count, 2)
+	}
+}
+
+func TestQueryMerged(t *testing.T) {
+	configString := `
+tables:
+  sockets:
+    fields:
+      id: 'string'
+      channelId: 'string'
+      updatedAt: 'integer'
+      expiresAt: 'integer'
+    expireKey: 'expiresAt'
+    hashKey: 'id'
+    subIndices:
+      recent:
+        hashKey: 'channelId'
+        sortKey: 'updatedAt'
+`
+	bingo := newBingo()
+	if err := ParseConfigString(bingo, configString); err != nil {
+		t.Fatal(err)
+	}
+	table := bingo.tables["sockets"]
+
+	for i, updatedAt := range []int64{5, 1, 4, 2, 3, 6} {
+		data := Data{"channelId": fmt.Sprintf("%d", i%3), "id": fmt.Sprintf("soc%d", i), "updatedAt": updatedAt}
+		table.Put(&data, nil)
+	}
+
+	index := table.Index("recent")
+	cursors := []*PartitionCursor{{Hash: "0"}, {Hash: "1"}, {Hash: "1"}}
+	result, next, _ := index.QueryMerged(cursors, &ScanOptions{Limit: 3})
+	for i, expectedValue := range []int64{1, 2, 3} {
+		if actualValue := result[i]["updatedAt"]; actualValue != expectedValue {
+			t.Errorf("order different at %d. Got %v expected %v", i, actualValue, expectedValue)
+		}
+	}
+	if actualValue, expectedValue := len(next), 1; actualValue != expectedValue {
+		t.Fatalf("next different. Got %v expected %v", actualValue, expectedValue)
+	}
+
+	result, next, _ = index.QueryMerged(next, &ScanOptions{Limit: 3})
+	if actualValue, expectedValue := len(result), 1; actualValue != expectedValue {
+		t.Errorf("size different. Got %v expected %v", actualValue, expectedValue)
+	}
+	if next != nil {
+		t.Errorf("next must be nil when every partition is exhausted. Got %v", next)
+	}
+
+	cursors = []*PartitionCursor{{Hash: "0"}, {Hash: "1"}, {Hash: "2"}}
+	result, _, _ = index.QueryMerged(cursors, &ScanOptions{Since: []interface{}{"5"}, Backward: true, Limit: 10})
+	for i, expectedValue := range []int64{5, 4, 3, 2, 1} {
+		if actualValue := result[i]["updatedAt"]; actualValue != expectedValue {
+			t.Errorf("order different at %d. Got %v expected %v", i, actualValue, expectedValue)
+		}
+	}
+}
+
+func TestQueryAsOf(t *testing.T) {
+	configString := `
+tables:
+  sockets:
+    fields:
+      id: 'string'
+      channelId: 'string'
+      updatedAt: 'integer'
+      expiresAt: 'integer'
+    expireKey: 'expiresAt'
+    hashKey: 'channelId'
+    sortKey: 'id'
+    snapshotTtl: 60000
+    subIndices:
+      recent:
+        hashKey: 'channelId'
+        sortKey: 'updatedAt'
+  plain:
+    fields:
+      id: 'string'
+      name: 'string'
+      expiresAt: 'integer'
+    expireKey: 'expiresAt'
+    hashKey: 'id'
+    sortKey: 'name'
+`
+	bingo := newBingo()
+	if err := ParseConfigString(bingo, configString); err != nil {
+		t.Fatal(err)
+	}
+	table := bingo.tables["sockets"]
+
+	for i := 0; i < 4; i++ {
+		data := Data{"channelId": "1", "id": fmt.Sprintf("soc%d", i), "updatedAt": int64(i)}
+		table.Put(&data, nil)
+	}
+
+	version, _ := table.Snapshot()
+	result, next, _ := table.QueryAsOf("recent", "1", &ScanOptions{Limit: 2}, version)
+	if actualValue, expectedValue := len(result), 2; actualValue != expectedValue {
+		t.Errorf("size different. Got %v expected %v", actualValue, expectedValue)
+	}
+
+	// soc0 moves past the page, soc2 is removed and soc9 is added after the snapshot
+	data := Data{"channelId": "1", "id": "soc0", "updatedAt": int64(10)}
+	table.Put(&data, nil)
+	table.Remove("1", "soc2")
+	data = Data{"channelId": "1", "id": "soc9", "updatedAt": int64(2)}
+	table.Put(&data, nil)
+
+	result, next, _ = table.QueryAsOf("recent", "1", &ScanOptions{Since: next, Limit: 10}, version)
+	if actualValue, expectedValue := len(result), 2; actualValue != expectedValue {
+		t.Fatalf("size different. Got %v expected %v", actualValue, expectedValue)
+	}
+	for i, expectedValue := range []string{"soc2", "soc3"} {
+		if actualValue := result[i]["id"]; actualValue != expectedValue {
+			t.Errorf("document different at %d. Got %v expected %v", i, actualValue, expectedValue)
+		}
+	}
+	if next != nil {
+		t.Errorf("next must be nil at the end. Got %v", next)
+	}
+
+	result, _, _ = table.QueryAsOf("", "1", &ScanOptions{Limit: 10, Backward: true}, version)
+	if actualValue, expectedValue := len(result), 4; actualValue != expectedValue {
+		t.Errorf("size different. Got %v expected %v", actualValue, expectedValue)
+	}
+	if actualValue, expectedValue := result[3]["updatedAt"], int64(0); actualValue != expectedValue {
+		t.Errorf("old document must be read. Got %v expected %v", actualValue, expectedValue)
+	}
+
+	// A write reverted by a transaction is not read twice
+	bingo.tables["plain"].Put(&Data{"id": "taken", "name": "a"}, nil)
+	data = Data{"channelId": "1", "id": "soc3", "updatedAt": int64(20)}
+	_, err := bingo.Transact([]*Write{
+		{Table: table, Update: &Update{Set: &data}},
+		{Table: bingo.tables["plain"], Update: &Update{Set: &Data{"id": "taken", "name": "a"}, Mode: INSERT}},
+	})
+	if err == nil {
+		t.Fatal("transaction must fail")
+	}
+	result, _, _ = table.QueryAsOf("", "1", &ScanOptions{Limit: 10}, version)
+	if actualValue, expectedValue := len(result), 4; actualValue != expectedValue {
+		t.Errorf("size different. Got %v expected %v", actualValue, expectedValue)
+	}
+	if actualValue, expectedValue := len(table.history.retired), 2; actualValue != expectedValue {
+		t.Errorf("retired different. Got %v expected %v", actualValue, expectedValue)
+	}
+
+	// Documents are dropped once no snapshot is open before they were retired
+	table.history.snapshots[version] = 0
+	table.Remove("1", "soc3")
+	if _, _, err := table.QueryAsOf("", "1", &ScanOptions{Limit: 10}, version); err == nil || err.Error() != SnapshotExpired {
+		t.Errorf("snapshot must expire once it was not read for the ttl. Got %v", err)
+	}
+	if actualValue, expectedValue := len(table.history.retired), 0; actualValue != expectedValue {
+		t.Errorf("retired different. Got %v expected %v", actualValue, expectedValue)
+	}
+	if list := table.primaryIndex.retiredList("1"); list != nil && list.Size() != 0 {
+		t.Errorf("retired documents must be released from the index. Got %v", list.Size())
+	}
+
+	later, _ := table.Snapshot()
+	data = Data{"channelId": "1", "id": "soc1", "updatedAt": int64(30)}
+	table.Put(&data, nil)
+	table.history.snapshots[later] = 0
+	table.Snapshot()
+	if actualValue, expectedValue := len(table.history.retired), 0; actualValue != expectedValue {
+		t.Errorf("documents retired after the last snapshot must be dropped. Got %v expected %v", actualValue, expectedValue)
+	}
+
+	// A partition retiring its first document while it is read is merged
+	data = Data{"channelId": "2", "id": "soc0", "updatedAt": int64(0)}
+	table.Put(&data, nil)
+	version, _ = table.Snapshot()
+	table.QueryAsOf("", "2", &ScanOptions{Limit: 10}, version)
+	retired := table.primaryIndex.retiredList("2")
+	data = Data{"channelId": "2", "id": "soc0", "updatedAt": int64(1)}
+	table.Put(&data, nil)
+	if retired == nil || retired.Size() != 1 {
+		t.Error("documents retired must be kept in the list of a partition read as of a version")
+	}
+
+	// Lists of partitions are dropped once empty
+	table.Remove("2", "soc0")
+	if table.Index("").(*PrimaryIndex).counted("2") != nil || table.Index("recent").(*SubIndex).counted("2") != nil {
+		t.Error("counts of an empty partition must be dropped")
+	}
+	for snapshot := range table.history.snapshots {
+		table.history.snapshots[snapshot] = 0
+	}
+	table.Remove("1", "soc1")
+	if table.primaryIndex.retiredList("2") != nil || table.subIndices["recent"].retiredList("2") != nil {
+		t.Error("retained lists must be dropped once no snapshot is open")
+	}
+
+	plain := bingo.tables["plain"]
+	before := plain.version
+	plain.Remove("taken", "a")
+	if actualValue, expectedValue := plain.version, before+1; actualValue != expectedValue {
+		t.Errorf("version must be bumped on remove. Got %v expected %v", actualValue, expectedValue)
+	}
+
+	if _, err := plain.Snapshot(); err == nil {
+		t.Error("snapshot must fail on a table without snapshotTtl")
+	}
+}
+
+func TestRankAndOffset(t *testing.T) {
+	configString := `
+tables:
+  sockets:
+    fields:
+      id: 'string'
+      channelId: 'string'
+      updatedAt: 'integer'
+      expiresAt: 'integer'
+    expireKey: 'expiresAt'
+    hashKey: 'channelId'
+    sortKey: 'id'
+    subIndices:
+      recent:
+        hashKey: 'channelId'
+        sortKey: 'updatedAt'
+        order: 'desc'
+`
+	bingo := newBingo()
+	if err := ParseConfigString(bingo, configString); err != nil {
+		t.Fatal(err)
+	}
+	table := bingo.tables["sockets"]
+
+	for i, updatedAt := range []int64{4, 1, 3, 2, 3} {
+		data := Data{"channelId": "1", "id": fmt.Sprintf("soc%d", i), "updatedAt": updatedAt}
+		table.Put(&data, nil)
+	}
+
+	if rank, _ := table.Index("").Rank("1", "soc3"); rank != 3 {
+		t.Errorf("rank different. Got %v expected %v", rank, 3)
+	}
+	if rank, _ := table.Index("recent").Rank("1", []interface{}{"3"}); rank != 1 {
+		t.Errorf("rank different. Got %v expected %v", rank, 1)
+	}
+	if rank, _ := table.Index("recent").Rank("1", []interface{}{"3", "1", "soc4"}); rank != 2 {
+		t.Errorf("rank different. Got %v expected %v", rank, 2)
+	}
+	if _, err := table.Index("").Rank("1", "soc9"); err == nil {
+		t.Error("rank of a missing document must fail")
+	}
+
+	result, next, _ := table.Index("recent").Query("1", &ScanOptions{Offset: 3, Limit: 1})
+	if actualValue, expectedValue := result[0]["id"], "soc3"; actualValue != expectedValue {
+		t.Errorf("document different. Got %v expected %v", actualValue, expectedValue)
+	}
+	if next == nil {
+		t.Error("next must be given before the end")
+	}
+
+	filter, _ := ParseFilter("updatedAt >= 3")
+	result, _, _ = table.Index("").Query("1", &ScanOptions{Offset: 2, Limit: 10, Filter: filter})
+	if actualValue, expectedValue := len(result), 1; actualValue != expectedValue {
+		t.Errorf("size different. Got %v expected %v", actualValue, expectedValue)
+	}
+}
+
+func TestRankAtBoundariesAndAfterRemovals(t *testing.T) {
+	configString := `
+tables:
+  sockets:
+    fields:
+      id: 'string'
+      channelId: 'string'
+      updatedAt: 'integer'
+      expiresAt: 'integer'
+    expireKey: 'expiresAt'
+    hashKey: 'channelId'
+    sortKey: 'id'
+    subIndices:
+      recent:
+        hashKey: 'channelId'
+        sortKey: 'updatedAt'
+        order: 'desc'
+`
+	bingo := newBingo()
+	if err := ParseConfigString(bingo, configString); err != nil {
+		t.Fatal(err)
+	}
+	table := bingo.tables["sockets"]
+
+	for channel := 0; channel < 3; channel++ {
+		for i := 0; i < 100; i++ {
+			data := Data{"channelId": fmt.Sprint(channel), "id": fmt.Sprintf("soc%03d", i), "updatedAt": int64(i % 7)}
+			table.Put(&data, nil)
+		}
+	}
+	for i := 0; i < 100; i += 3 {
+		table.Remove("1", fmt.Sprintf("soc%03d", i))
+	}
+	table.Remove("2", "soc000")
+	table.Remove("2", "soc099")
+
+	// Ranks are the positions of documents in a scan of their partition
+	everything, _ := ParseFilter("updatedAt >= 0")
+	for _, indexName := range []string{"", "recent"} {
+		index := table.Index(indexName)
+		for _, channel := range []string{"0", "1", "2"} {
+			docs, _, _ := index.Query(channel, &ScanOptions{Limit: 100})
+			for i, doc := range docs {
+				sort := interface{}(doc["id"])
+				if indexName == "recent" {
+					sort = []interface{}{doc["updatedAt"], channel, doc["id"]}
+				}
+				if rank, err := index.Rank(channel, sort); err != nil || rank != int64(i) {
+					t.Fatalf("rank different on %q of %v at %v. Got %v, %v expected %v", indexName, channel, doc["id"], rank, err, i)
+				}
+			}
+
+			// Seeking past an offset reads what walking past it does
+			for _, offset := range []int{1, 10, len(docs) - 1, len(docs), len(docs) + 1} {
+				for _, backward := range []bool{false, true} {
+					seeked, seekedNext, _ := index.Query(channel, &ScanOptions{Offset: offset, Limit: 5, Backward: backward})
+					walked, walkedNext, _ := index.Query(channel, &ScanOptions{Offset: offset, Limit: 5, Backward: backward, Filter: everything})
+					if !reflect.DeepEqual(seeked, walked) || !reflect.DeepEqual(seekedNext, walkedNext) {
+						t.Fatalf("page different on %q of %v at offset %v. Got %v expected %v", indexName, channel, offset, seeked, walked)
+					}
+				}
+			}
+		}
+	}
+
+	if _, err := table.Index("").Rank("1", "soc000"); err == nil {
+		t.Error("rank of a removed document must fail")
+	}
+	if rank, _ := table.Index("").Rank("2", "soc001"); rank != 0 {
+		t.Errorf("rank of the first document different. Got %v expected %v", rank, 0)
+	}
+	if rank, _ := table.Index("").Rank("2", "soc098"); rank != 97 {
+		t.Errorf("rank of the last document different. Got %v expected %v", rank, 97)
+	}
+	if rank, _ := table.Index("recent").Rank("0", []interface{}{"0"}); rank != 85 {
+		t.Errorf("rank of the first of a sort key different. Got %v expected %v", rank, 85)
+	}
+}
+
+func TestCountRange(t *testing.T) {
+	configString := `
+tables:
+  sockets:
+    fields:
+      id: 'string'
+      channelId: 'string'
+      updatedAt: 'integer'
+      expiresAt: 'integer'
+    expireKey: 'expiresAt'
+    hashKey: 'channelId'
+    sortKey: 'id'
+    subIndices:
+      recent:
+        hashKey: 'channelId'
+        sortKey: 'updatedAt'
+        order: 'desc'
+`
+	bingo := newBingo()
+	if err := ParseConfigString(bingo, configString); err != nil {
+		t.Fatal(err)
+	}
+	table := bingo.tables["sockets"]
+
+	for i := 0; i < 50; i++ {
+		data := Data{"channelId": "1", "id": fmt.Sprintf("soc%02d", i), "updatedAt": int64(i % 5)}
+		table.Put(&data, nil)
+	}
+	table.Remove("1", "soc10")
+	table.Remove("1", "soc49")
+
+	// Counts by the positions of bounds are those of a walk over the range
+	everything, _ := ParseFilter("updatedAt >= 0")
+	bounds := map[string][]interface{}{
+		"":       {nil, "soc00", "soc10", "soc25", "soc49", "soc99", "a"},
+		"recent": {nil, []interface{}{"0"}, []interface{}{"2"}, []interface{}{"4"}, []interface{}{"2", "1", "soc22"}, []interface{}{"9"}},
+	}
+	for indexName, values := range bounds {
+		index := table.Index(indexName)
+		for _, since := range values {
+			for _, until := range values {
+				for _, exclusive := range []bool{false, true} {
+					for _, backward := range []bool{false, true} {
+						options := ScanOptions{Since: since, Until: until, SinceExclusive: exclusive, UntilExclusive: !exclusive, Backward: backward}
+						counted, _ := index.Count("1", &options)
+						options.Filter = everything
+						walked, _ := index.Count("1", &options)
+						if counted != walked {
+							t.Errorf("count different on %q from %v to %v, exclusive %v, backward %v. Got %v expected %v",
+								indexName, since, until, exclusive, backward, counted, walked)
+						}
+					}
+				}
+			}
+		}
+	}
+}
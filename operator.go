@@ -0,0 +1,440 @@
+package bingodb
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/zoyi/skiplist/lib"
+	"reflect"
+)
+
+const (
+	INSERT = "insert"
+	UPDATE = "update"
+)
+
+// Update describes a single write on a table. Set and SetOnInsert build the
+// document to insert, the other operators are applied to the current
+// document while the table is locked.
+// Mode INSERT fails when the document exists, UPDATE when it does not;
+// an empty Mode upserts.
+type Update struct {
+	Set         *Data
+	SetOnInsert *Data
+	Inc         *Data
+	Unset       *Data
+	Min         *Data
+	Max         *Data
+	Push        *Data
+	AddToSet    *Data
+	Pull        *Data
+	If          *Data
+	Mode        string
+}
+
+type operators struct {
+	inc      Data
+	unset    []string
+	min      Data
+	max      Data
+	push     map[string]*pushOperand
+	addToSet map[string][]interface{}
+	pull     map[string][]interface{}
+}
+
+type pushOperand struct {
+	each  []interface{}
+	slice *int
+}
+
+func (table *Table) parseOperators(update *Update) (*operators, error) {
+	var err error
+	ops := &operators{}
+
+	if ops.inc, err = table.parseOperands(update.Inc, "$inc"); err != nil {
+		return nil, err
+	}
+	for name, operand := range ops.inc {
+		if _, ok := toNumber(operand); !ok {
+			return nil, fmt.Errorf(FieldNotNumeric, name, operand)
+		}
+	}
+
+	if ops.min, err = table.parseOperands(update.Min, "$min"); err != nil {
+		return nil, err
+	}
+	if ops.max, err = table.parseOperands(update.Max, "$max"); err != nil {
+		return nil, err
+	}
+
+	if ops.push, err = table.parsePushOperands(update.Push); err != nil {
+		return nil, err
+	}
+	if ops.addToSet, err = table.parseArrayOperands(update.AddToSet, "$addToSet", "$each"); err != nil {
+		return nil, err
+	}
+	if ops.pull, err = table.parseArrayOperands(update.Pull, "$pull", "$in"); err != nil {
+		return nil, err
+	}
+
+	if update.Unset != nil {
+		for name := range *update.Unset {
+			if table.isKeyField(name) {
+				return nil, fmt.Errorf(KeyFieldModified, name, "$unset")
+			}
+			if table.expireKeyRequired && name == table.expireField.Name {
+				return nil, fmt.Errorf(KeyFieldModified, name, "$unset")
+			}
+			ops.unset = append(ops.unset, name)
+		}
+	}
+
+	return ops, nil
+}
+
+func (table *Table) parseOperands(data *Data, op string) (Data, error) {
+	if data == nil || data.Length() == 0 {
+		return nil, nil
+	}
+
+	operands := make(Data)
+	for name, raw := range *data {
+		if table.isKeyField(name) {
+			return nil, fmt.Errorf(KeyFieldModified, name, op)
+		}
+		if field, ok := table.fields[name]; ok {
+			value, err := field.Parse(raw)
+			if err != nil {
+				return nil, err
+			}
+			operands[name] = value
+		} else if value, ok := toNumber(raw); ok {
+			operands[name] = value
+		} else {
+			operands[name] = raw
+		}
+	}
+	return operands, nil
+}
+
+// parsePushOperands parses operands of $push, which are either a single value
+// or {"$each": [values], "$slice": n}. A negative $slice keeps the last n
+// elements, a positive one the first n.
+func (table *Table) parsePushOperands(data *Data) (map[string]*pushOperand, error) {
+	if data == nil || data.Length() == 0 {
+		return nil, nil
+	}
+
+	operands := make(map[string]*pushOperand)
+	for name, raw := range *data {
+		if err := table.checkArrayField(name, "$push"); err != nil {
+			return nil, err
+		}
+
+		operand := &pushOperand{}
+		modifiers, ok := toModifiers(raw)
+		if !ok {
+			operand.each = []interface{}{raw}
+			operands[name] = operand
+			continue
+		}
+
+		each, ok := modifiers["$each"].([]interface{})
+		if !ok {
+			return nil, fmt.Errorf(ArrayOperandError, "$push", name)
+		}
+		operand.each = each
+
+		if rawSlice, present := modifiers["$slice"]; present {
+			number, ok := toNumber(rawSlice)
+			if !ok {
+				return nil, fmt.Errorf(ArrayOperandError, "$push", name)
+			}
+			slice := int(toFloat(number))
+			operand.slice = &slice
+		}
+		operands[name] = operand
+	}
+	return operands, nil
+}
+
+// parseArrayOperands parses operands which are either a single value or
+// {modifier: [values]}.
+func (table *Table) parseArrayOperands(data *Data, op string, modifier string) (map[string][]interface{}, error) {
+	if data == nil || data.Length() == 0 {
+		return nil, nil
+	}
+
+	operands := make(map[string][]interface{})
+	for name, raw := range *data {
+		if err := table.checkArrayField(name, op); err != nil {
+			return nil, err
+		}
+
+		modifiers, ok := toModifiers(raw)
+		if !ok {
+			operands[name] = []interface{}{raw}
+			continue
+		}
+
+		values, ok := modifiers[modifier].([]interface{})
+		if !ok {
+			return nil, fmt.Errorf(ArrayOperandError, op, name)
+		}
+		operands[name] = values
+	}
+	return operands, nil
+}
+
+// Fields defined in the schema hold a single string or integer,
+// so only undefined fields can be used as arrays.
+func (table *Table) checkArrayField(name string, op string) error {
+	if table.isKeyField(name) {
+		return fmt.Errorf(KeyFieldModified, name, op)
+	}
+	if _, ok := table.fields[name]; ok {
+		return fmt.Errorf(FieldNotArray, name)
+	}
+	return nil
+}
+
+func toModifiers(raw interface{}) (map[string]interface{}, bool) {
+	switch raw.(type) {
+	case map[string]interface{}:
+		return raw.(map[string]interface{}), true
+
+	case Data:
+		return raw.(Data), true
+	}
+	return nil, false
+}
+
+func (table *Table) isKeyField(name string) bool {
+	if hashKey := table.HashKey(); hashKey != nil && hashKey.Name == name {
+		return true
+	}
+	if sortKey := table.SortKey(); sortKey != nil && sortKey.Name == name {
+		return true
+	}
+	return false
+}
+
+func (ops *operators) empty() bool {
+	return len(ops.inc) == 0 && len(ops.unset) == 0 && len(ops.min) == 0 && len(ops.max) == 0 &&
+		len(ops.push) == 0 && len(ops.addToSet) == 0 && len(ops.pull) == 0
+}
+
+// apply returns a copy of doc with the operators applied. A field missing
+// in doc is set to the operand of $inc, $min and $max, and is an empty
+// array for $push and $addToSet.
+func (ops *operators) apply(doc *Document) (*Document, error) {
+	if ops.empty() {
+		return doc, nil
+	}
+
+	data := make(Data)
+	for k, v := range doc.data {
+		data[k] = v
+	}
+
+	for name, operand := range ops.inc {
+		if current, ok := data[name]; ok && current != nil {
+			sum, ok := addNumbers(current, operand)
+			if !ok {
+				return nil, fmt.Errorf(FieldNotNumeric, name, operand)
+			}
+			data[name] = sum
+		} else {
+			data[name] = operand
+		}
+	}
+
+	for name, operand := range ops.min {
+		if current, ok := data[name]; ok && current != nil {
+			diff, ok := compareValues(operand, current)
+			if !ok {
+				return nil, fmt.Errorf(FieldNotComparable, name, operand)
+			}
+			if diff < 0 {
+				data[name] = operand
+			}
+		} else {
+			data[name] = operand
+		}
+	}
+
+	for name, operand := range ops.max {
+		if current, ok := data[name]; ok && current != nil {
+			diff, ok := compareValues(operand, current)
+			if !ok {
+				return nil, fmt.Errorf(FieldNotComparable, name, operand)
+			}
+			if diff > 0 {
+				data[name] = operand
+			}
+		} else {
+			data[name] = operand
+		}
+	}
+
+	for name, operand := range ops.push {
+		current, ok := toArray(data[name])
+		if !ok {
+			return nil, fmt.Errorf(FieldNotArray, name)
+		}
+		list := append(current, operand.each...)
+		if slice := operand.slice; slice != nil {
+			if *slice < 0 && len(list) > -*slice {
+				list = list[len(list)+*slice:]
+			} else if *slice >= 0 && len(list) > *slice {
+				list = list[:*slice]
+			}
+		}
+		data[name] = list
+	}
+
+	for name, values := range ops.addToSet {
+		list, ok := toArray(data[name])
+		if !ok {
+			return nil, fmt.Errorf(FieldNotArray, name)
+		}
+		for _, value := range values {
+			if indexOf(list, value) < 0 {
+				list = append(list, value)
+			}
+		}
+		data[name] = list
+	}
+
+	for name, values := range ops.pull {
+		if _, present := data[name]; !present {
+			continue
+		}
+		current, ok := toArray(data[name])
+		if !ok {
+			return nil, fmt.Errorf(FieldNotArray, name)
+		}
+		list := make([]interface{}, 0, len(current))
+		for _, element := range current {
+			if indexOf(values, element) < 0 {
+				list = append(list, element)
+			}
+		}
+		data[name] = list
+	}
+
+	for _, name := range ops.unset {
+		delete(data, name)
+	}
+
+	return &Document{data: data, schema: doc.schema}, nil
+}
+
+// toArray returns a copy of an array field so the current document is never
+// modified in place. A missing field is an empty array.
+func toArray(raw interface{}) ([]interface{}, bool) {
+	if raw == nil {
+		return []interface{}{}, true
+	}
+	if current, ok := raw.([]interface{}); ok {
+		list := make([]interface{}, len(current))
+		copy(list, current)
+		return list, true
+	}
+	return nil, false
+}
+
+func indexOf(list []interface{}, value interface{}) int {
+	for i, element := range list {
+		if equalValues(element, value) {
+			return i
+		}
+	}
+	return -1
+}
+
+// equalValues reports whether two values are equal, treating numbers of
+// different representations such as json.Number and int64 as the same.
+func equalValues(a, b interface{}) bool {
+	if diff, ok := compareValues(a, b); ok {
+		return diff == 0
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func toNumber(raw interface{}) (interface{}, bool) {
+	switch raw.(type) {
+	case int64:
+		return raw.(int64), true
+
+	case int:
+		return int64(raw.(int)), true
+
+	case float64:
+		return raw.(float64), true
+
+	case json.Number:
+		if value, err := raw.(json.Number).Int64(); err == nil {
+			return value, true
+		}
+		if value, err := raw.(json.Number).Float64(); err == nil {
+			return value, true
+		}
+	}
+	return nil, false
+}
+
+func toFloat(number interface{}) float64 {
+	if value, ok := number.(int64); ok {
+		return float64(value)
+	}
+	return number.(float64)
+}
+
+func addNumbers(a, b interface{}) (interface{}, bool) {
+	x, ok := toNumber(a)
+	if !ok {
+		return nil, false
+	}
+	y, ok := toNumber(b)
+	if !ok {
+		return nil, false
+	}
+
+	xInt, xOk := x.(int64)
+	yInt, yOk := y.(int64)
+	if xOk && yOk {
+		return xInt + yInt, true
+	}
+	return toFloat(x) + toFloat(y), true
+}
+
+// compareValues compares two numbers or two strings.
+// It reports false when the values cannot be compared with each other.
+func compareValues(a, b interface{}) (int, bool) {
+	if x, ok := toNumber(a); ok {
+		y, ok := toNumber(b)
+		if !ok {
+			return 0, false
+		}
+		xInt, xOk := x.(int64)
+		yInt, yOk := y.(int64)
+		if xOk && yOk {
+			return NumberComparator(xInt, yInt), true
+		}
+		switch xFloat, yFloat := toFloat(x), toFloat(y); {
+		case xFloat > yFloat:
+			return 1, true
+		case xFloat < yFloat:
+			return -1, true
+		default:
+			return 0, true
+		}
+	}
+
+	_, aOk := a.(string)
+	_, bOk := b.(string)
+	if aOk && bOk {
+		return lib.StringComparator(a, b), true
+	}
+	return 0, false
+}
@@ -0,0 +1,158 @@
+package bingodb
+
+import (
+	"errors"
+	"time"
+)
+
+// history keeps the documents replaced or removed in a table while a
+// snapshot open before may still read them. A snapshot stays open for ttl
+// milliseconds after it was pinned or read at last.
+type history struct {
+	ttl int64
+	// snapshots holds when each open snapshot, by version, expires
+	snapshots map[int64]int64
+	// retired holds the documents kept in the order they were retired,
+	// which is the order of their until
+	retired []*retiredDocument
+}
+
+type retiredDocument struct {
+	doc *Document
+	// until is the version from which doc is no longer current
+	until int64
+}
+
+func newHistory(ttl int64) *history {
+	return &history{ttl: ttl, snapshots: make(map[int64]int64), retired: make([]*retiredDocument, 0)}
+}
+
+// currentAt reports whether the retired document was current at version.
+func (retired *retiredDocument) currentAt(version int64) bool {
+	return retired.doc.version <= version && version < retired.until
+}
+
+func nowMillis() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
+
+// retire keeps doc, which is no longer current from version until, for
+// the snapshots open. The caller must hold the table lock.
+func (table *Table) retire(doc *Document, until int64) {
+	history := table.history
+	if history == nil {
+		return
+	}
+
+	table.trimHistory()
+	if len(history.snapshots) == 0 {
+		return
+	}
+
+	retired := &retiredDocument{doc: doc, until: until}
+	history.retired = append(history.retired, retired)
+	table.primaryIndex.retain(retired)
+	for _, index := range table.subIndices {
+		index.retain(retired)
+	}
+}
+
+// unretire undoes the last retire when it was of doc, for a write reverted.
+// The caller must hold the table lock.
+func (table *Table) unretire(doc *Document) {
+	history := table.history
+	if history == nil || len(history.retired) == 0 {
+		return
+	}
+
+	last := len(history.retired) - 1
+	if retired := history.retired[last]; retired.doc == doc {
+		history.retired = history.retired[:last]
+		table.forget(retired)
+	}
+}
+
+// trimHistory closes the snapshots expired and drops the documents no
+// open snapshot can read, those retired up to the oldest of them.
+// The caller must hold the table lock.
+func (table *Table) trimHistory() {
+	history := table.history
+	current := nowMillis()
+
+	oldest := int64(-1)
+	for version, expireAt := range history.snapshots {
+		if expireAt <= current {
+			delete(history.snapshots, version)
+		} else if oldest < 0 || version < oldest {
+			oldest = version
+		}
+	}
+
+	dropped := 0
+	for _, retired := range history.retired {
+		if oldest >= 0 && retired.until > oldest {
+			break
+		}
+		table.forget(retired)
+		dropped++
+	}
+	history.retired = history.retired[dropped:]
+
+	if oldest < 0 {
+		table.primaryIndex.dropRetired()
+		for _, index := range table.subIndices {
+			index.dropRetired()
+		}
+	}
+}
+
+// forget drops a retired document from the indices.
+func (table *Table) forget(retired *retiredDocument) {
+	table.primaryIndex.release(retired)
+	for _, index := range table.subIndices {
+		index.release(retired)
+	}
+}
+
+// Snapshot pins the current version of the table to scan as of it with
+// QueryAsOf, while documents replaced or removed since are retained.
+func (table *Table) Snapshot() (int64, error) {
+	if table.history == nil {
+		return 0, errors.New(SnapshotDisabled)
+	}
+
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+
+	table.trimHistory()
+	table.history.snapshots[table.version] = nowMillis() + table.history.ttl
+	return table.version, nil
+}
+
+// QueryAsOf scans a partition of the index as it was at version, pinned
+// by Snapshot, and keeps the snapshot open for another snapshot ttl.
+// It fails once the snapshot is closed, when it was not read for the ttl.
+// Writes are not held back while the page is read.
+func (table *Table) QueryAsOf(indexName string, hash interface{}, options *ScanOptions, version int64) ([]Data, interface{}, error) {
+	index := table.Index(indexName)
+	if index == nil {
+		return make([]Data, 0), nil, errors.New(IndexNotFound)
+	}
+	if table.history == nil {
+		return make([]Data, 0), nil, errors.New(SnapshotDisabled)
+	}
+
+	table.mutex.Lock()
+	table.trimHistory()
+	_, open := table.history.snapshots[version]
+	if open {
+		table.history.snapshots[version] = nowMillis() + table.history.ttl
+		openAsOf(index, hash)
+	}
+	table.mutex.Unlock()
+
+	if !open {
+		return make([]Data, 0), nil, errors.New(SnapshotExpired)
+	}
+	return queryAsOf(index, hash, options, version)
+}
@@ -5,8 +5,10 @@ import (
 	"errors"
 	"fmt"
 	"github.com/zoyi/skiplist/lib"
+	"golang.org/x/text/unicode/norm"
 	"reflect"
 	"strconv"
+	"strings"
 	"sync"
 )
 
@@ -16,8 +18,10 @@ type FieldSchema struct {
 }
 
 type KeySchema struct {
-	hashKey *FieldSchema
-	sortKey *FieldSchema
+	hashKey    *FieldSchema
+	sortKey    *FieldSchema
+	descending bool
+	collation  string
 }
 
 type TableSchema struct {
@@ -37,6 +41,9 @@ type Table struct {
 	rowLocks          *sync.Map
 	metricsConfig     *MetricsConfig
 	expireKeyRequired bool
+	version           int64
+	// history retains replaced and removed documents for snapshots, if enabled
+	history *history
 }
 
 type TableInfo struct {
@@ -241,6 +248,35 @@ func GeneralCompare(a, b interface{}) int {
 	return lib.StringComparator(a, b)
 }
 
+// Collate converts a parsed key value into the form stored in an index,
+// so that values equal under the collation of the index share one key.
+// Non-string values are returned as they are.
+func (schema *KeySchema) Collate(value interface{}) interface{} {
+	str, ok := value.(string)
+	if !ok {
+		return value
+	}
+
+	switch schema.collation {
+	case NOCASE:
+		return strings.ToLower(str)
+	case NFC:
+		return norm.NFC.String(str)
+	case NFC_NOCASE:
+		return strings.ToLower(norm.NFC.String(str))
+	}
+	return str
+}
+
+// CompareSort compares two collated sort values honoring the order of the index.
+// A nil value is an open bound and always comes first.
+func (schema *KeySchema) CompareSort(a, b interface{}) int {
+	if schema.descending && a != nil && b != nil {
+		return -GeneralCompare(a, b)
+	}
+	return GeneralCompare(a, b)
+}
+
 func (schema *TableSchema) Compare(a, b *Document) int {
 	if a == nil || b == nil {
 		if a == b {
@@ -258,7 +294,7 @@ func (schema *TableSchema) Compare(a, b *Document) int {
 	return GeneralCompare(a.Get(schema.primaryKey.sortKey), b.Get(schema.primaryKey.sortKey))
 }
 
-func (table *Table) Index(name string) IndexInterface {
+func (table *Table) Index(name string) RangeIndex {
 	if len(name) == 0 {
 		return table.primaryIndex
 	} else if index, ok := table.subIndices[name]; ok {
@@ -272,44 +308,131 @@ func (table *Table) PrimaryIndex() *PrimaryIndex {
 }
 
 func (table *Table) Put(setData *Data, setOnInsertData *Data) (*Document, *Document, bool, error) {
-	set, setErr := ParseDoc(setData, table.TableSchema)
-	setOnInsert, setOnInsertErr := ParseDoc(setOnInsertData, table.TableSchema)
+	return table.Apply(&Update{Set: setData, SetOnInsert: setOnInsertData})
+}
+
+func (table *Table) Apply(update *Update) (*Document, *Document, bool, error) {
+	put, err := table.preparePut(update)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+
+	return table.put(put)
+}
+
+// pendingPut is an update parsed against the schema of the table,
+// ready to be written while holding the table lock.
+type pendingPut struct {
+	set      *Document
+	merged   *Document
+	inserted *Document
+	ops      *operators
+	cond     *condition
+	mode     string
+}
+
+func (table *Table) preparePut(update *Update) (*pendingPut, error) {
+	set, setErr := ParseDoc(update.Set, table.TableSchema)
+	setOnInsert, setOnInsertErr := ParseDoc(update.SetOnInsert, table.TableSchema)
 	if setErr != nil {
-		return nil, nil, false, setErr
+		return nil, setErr
 	}
 	if setOnInsertErr != nil {
-		return nil, nil, false, setOnInsertErr
+		return nil, setOnInsertErr
 	}
 	if set == nil && setOnInsert == nil {
-		return nil, nil, false, errors.New(SetOrInsertMissing)
+		return nil, errors.New(SetOrInsertMissing)
+	}
+
+	switch update.Mode {
+	case "", INSERT, UPDATE:
+	default:
+		return nil, fmt.Errorf(UnknownMode, update.Mode)
+	}
+
+	ops, err := table.parseOperators(update)
+	if err != nil {
+		return nil, err
+	}
+	cond, err := table.parseCondition(update.If)
+	if err != nil {
+		return nil, err
 	}
 
 	merged := Merge(set, setOnInsert)
 
 	if merged.Fetch(table.HashKey().Name) == nil {
-		return nil, nil, false, errors.New(HashKeyMissing)
+		return nil, errors.New(HashKeyMissing)
 	}
 	if table.SortKey() != nil && merged.Fetch(table.SortKey().Name) == nil {
-		return nil, nil, false, errors.New(SortKeyMissing)
+		return nil, errors.New(SortKeyMissing)
 	}
 	if table.expireKeyRequired && merged.Fetch(table.expireField.Name) == nil {
-		return nil, nil, false, errors.New(ExpireKeyMissing)
+		return nil, errors.New(ExpireKeyMissing)
 	}
 
+	inserted, err := ops.apply(merged)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pendingPut{set: set, merged: merged, inserted: inserted, ops: ops, cond: cond, mode: update.Mode}, nil
+}
+
+// put writes a prepared update. The caller must hold the table lock.
+func (table *Table) put(put *pendingPut) (*Document, *Document, bool, error) {
+	var updateErr error
 	onUpdate := func(oldRaw interface{}) interface{} {
 		old := oldRaw.(*Document)
-		return old.Merge(set)
+		newbie, err := put.ops.apply(old.Merge(put.set))
+		if err != nil {
+			updateErr = err
+			return old
+		}
+		return &Document{data: newbie.data, schema: newbie.schema, version: table.version}
 	}
 
-	table.mutex.Lock()
-	defer table.mutex.Unlock()
-
 	//keyTuple := merged.NewKeyTuple(table.primaryKey)
 	//mutex := table.lockForRead(keyTuple)
 	//defer mutex.Unlock()
 
+	var current *Document
+	if put.cond != nil || put.mode != "" || table.history != nil {
+		current = table.primaryIndex.get(put.merged.Get(table.HashKey()), put.merged.Get(table.SortKey()))
+	}
+	if put.cond != nil || put.mode != "" {
+		if put.mode == UPDATE && current == nil {
+			return nil, nil, false, errors.New(DocumentNotFound)
+		}
+		if put.mode == INSERT && current != nil {
+			return nil, nil, false, &ConditionError{Current: current}
+		}
+		if !put.cond.match(current) {
+			return nil, nil, false, &ConditionError{Current: current}
+		}
+	}
+
+	// Every write gets the next version of the table
+	table.version++
+	inserted := &Document{data: put.inserted.data, schema: put.inserted.schema, version: table.version}
+
+	// Retired before it is replaced, so that snapshots read it throughout
+	if current != nil {
+		table.retire(current, table.version)
+	}
+
 	// Insert doc into primary index
-	old, newbie, replaced := table.primaryIndex.put(merged, onUpdate)
+	old, newbie, replaced := table.primaryIndex.put(inserted, onUpdate)
+	if updateErr != nil {
+		if current != nil {
+			table.unretire(current)
+		}
+		table.version--
+		return nil, nil, false, updateErr
+	}
 
 	// Update for sub index
 	for _, index := range table.subIndices {
@@ -330,14 +453,42 @@ func (table *Table) Put(setData *Data, setOnInsertData *Data) (*Document, *Docum
 }
 
 func (table *Table) Remove(hash interface{}, sort interface{}) (*Document, error) {
+	return table.RemoveIf(hash, sort, nil)
+}
+
+// RemoveIf removes the document only if the condition holds on it.
+func (table *Table) RemoveIf(hash interface{}, sort interface{}, ifData *Data) (*Document, error) {
 	//keyTuple, err := table.parseKey(hash, sort)
 	//if err != nil {
 	//	return nil, err
 	//}
 
+	cond, err := table.parseCondition(ifData)
+	if err != nil {
+		return nil, err
+	}
+
 	table.mutex.Lock()
 	defer table.mutex.Unlock()
 
+	return table.remove(hash, sort, cond)
+}
+
+// remove deletes a document if cond holds. The caller must hold the table lock.
+func (table *Table) remove(hash interface{}, sort interface{}, cond *condition) (*Document, error) {
+	current, err := table.primaryIndex.Get(hash, sort)
+	if cond != nil && !cond.match(current) {
+		return nil, &ConditionError{Current: current}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// A removal is a version of its own, and the document is retired
+	// before it is removed, so that snapshots read it throughout
+	table.version++
+	table.retire(current, table.version)
+
 	//value, ok := table.rowLocks.Load(*keyTuple)
 	//if ok {
 	//	mutex := value.(*sync.Mutex)
@@ -348,6 +499,8 @@ func (table *Table) Remove(hash interface{}, sort interface{}) (*Document, error
 
 	doc, err := table.primaryIndex.remove(hash, sort)
 	if err != nil {
+		table.unretire(current)
+		table.version--
 		return nil, err
 	}
 
@@ -360,6 +513,127 @@ func (table *Table) Remove(hash interface{}, sort interface{}) (*Document, error
 	return doc, nil
 }
 
+// RemoveRange removes the documents of a partition of the index in the
+// range of options, regardless of its limit, and returns how many were removed.
+func (table *Table) RemoveRange(indexName string, hash interface{}, options *ScanOptions) (int, error) {
+	index := table.Index(indexName)
+	if index == nil {
+		return 0, errors.New(IndexNotFound)
+	}
+
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+
+	docs, err := documents(index, hash, options)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, doc := range docs {
+		table.remove(doc.Get(table.HashKey()), doc.Get(table.SortKey()), nil)
+	}
+
+	return len(docs), nil
+}
+
+// RemoveByIndex removes the document found with hash and sort on the index,
+// or every document having them when all is set.
+func (table *Table) RemoveByIndex(indexName string, hash, sort interface{}, all bool) ([]*Document, error) {
+	index := table.Index(indexName)
+	if index == nil {
+		return nil, errors.New(IndexNotFound)
+	}
+
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+
+	docs, err := table.lookup(index, hash, sort, all)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, doc := range docs {
+		table.remove(doc.Get(table.HashKey()), doc.Get(table.SortKey()), nil)
+	}
+
+	return docs, nil
+}
+
+// ApplyByIndex applies update to the document found with hash and sort on
+// the index, or to every document having them when all is set.
+// The primary key of each document is added to the update, so it never
+// inserts a document. Updates are all prepared before any is applied, so
+// an invalid update changes none of the documents, while a write failing
+// on its document, such as by its condition, fails only in its result.
+func (table *Table) ApplyByIndex(indexName string, hash, sort interface{}, all bool, update *Update) ([]*WriteResult, error) {
+	index := table.Index(indexName)
+	if index == nil {
+		return nil, errors.New(IndexNotFound)
+	}
+
+	table.mutex.Lock()
+	defer table.mutex.Unlock()
+
+	docs, err := table.lookup(index, hash, sort, all)
+	if err != nil {
+		return nil, err
+	}
+
+	puts := make([]*pendingPut, len(docs))
+	for i, doc := range docs {
+		if puts[i], err = table.preparePut(table.updateOf(doc, update)); err != nil {
+			return nil, err
+		}
+	}
+
+	results := make([]*WriteResult, len(docs))
+	for i, put := range puts {
+		old, newbie, replaced, err := table.put(put)
+		results[i] = &WriteResult{Old: old, New: newbie, Replaced: replaced, Err: err}
+	}
+
+	return results, nil
+}
+
+// lookup returns the first document found with hash and sort on the index,
+// or all of them when all is set, those of the whole partition if sort is
+// not given. The caller must hold the table lock.
+func (table *Table) lookup(index IndexInterface, hash, sort interface{}, all bool) ([]*Document, error) {
+	if all {
+		return documents(index, hash, &ScanOptions{Since: sort, Until: sort})
+	}
+
+	doc, err := index.Get(hash, sort)
+	if err != nil {
+		return nil, err
+	}
+	return []*Document{doc}, nil
+}
+
+// updateOf returns a copy of update targeting the primary key of doc.
+func (table *Table) updateOf(doc *Document, update *Update) *Update {
+	target := *update
+
+	set := make(Data)
+	if update.Set != nil {
+		for k, v := range *update.Set {
+			set[k] = v
+		}
+	}
+	set[table.HashKey().Name] = doc.Get(table.HashKey())
+	if table.SortKey() != nil {
+		set[table.SortKey().Name] = doc.Get(table.SortKey())
+	}
+	target.Set = &set
+
+	if table.expireKeyRequired && set[table.expireField.Name] == nil {
+		setOnInsert := Data{table.expireField.Name: doc.Get(table.expireField)}
+		target.SetOnInsert = &setOnInsert
+	}
+
+	return &target
+}
+
 func (table *Table) RemoveByDocument(doc *Document) (*Document, error) {
 	hashValue := doc.Get(table.primaryKey.hashKey)
 	sortValue := doc.Get(table.primaryKey.sortKey)
@@ -0,0 +1,86 @@
+package bingodb
+
+import (
+	"fmt"
+)
+
+// TransactionError is returned when a write of a transaction fails.
+// None of the writes of the transaction are applied then.
+type TransactionError struct {
+	Index int
+	Err   error
+}
+
+func (err *TransactionError) Error() string {
+	return fmt.Sprintf(TransactionFailed, err.Index, err.Err)
+}
+
+// Transact applies writes across tables all or nothing. Tables are locked
+// in the same order as Batch does, and writes are applied in order so a
+// condition sees the writes before it. When a write fails, the writes
+// already applied are reverted, along with the versions of the tables,
+// before the locks are released.
+func (bingo *Bingo) Transact(writes []*Write) ([]*WriteResult, error) {
+	pending := make([]*pendingWrite, len(writes))
+	for i, write := range writes {
+		var err error
+		if pending[i], err = write.Table.prepareWrite(write); err != nil {
+			return nil, &TransactionError{Index: i, Err: err}
+		}
+	}
+
+	unlock := lockTables(writes)
+	defer unlock()
+
+	// Versions taken by the writes are given back when they are reverted
+	versions := make(map[*Table]int64)
+	for _, write := range writes {
+		versions[write.Table] = write.Table.version
+	}
+
+	results := make([]*WriteResult, len(writes))
+	for i, write := range writes {
+		results[i] = write.Table.write(write, pending[i])
+		if err := results[i].Err; err != nil {
+			for j := i - 1; j >= 0; j-- {
+				writes[j].Table.restore(results[j].New, results[j].Old)
+			}
+			for table, version := range versions {
+				table.version = version
+			}
+			return nil, &TransactionError{Index: i, Err: err}
+		}
+	}
+
+	return results, nil
+}
+
+// restore reverts a write by putting previous back in place of current,
+// either of which is nil when there was no document, and by undoing the
+// retire of previous. Previous is put back before current is removed and
+// before it is unretired, so that snapshots read it throughout. The caller
+// must hold the table lock.
+func (table *Table) restore(current *Document, previous *Document) {
+	keeper := table.bingo.keeper
+
+	if previous != nil {
+		table.primaryIndex.put(previous, nil)
+	} else if current != nil {
+		table.primaryIndex.remove(current.Get(table.HashKey()), current.Get(table.SortKey()))
+	}
+
+	if current != nil {
+		for _, index := range table.subIndices {
+			index.remove(current)
+		}
+		keeper.remove(table, current)
+	}
+
+	if previous != nil {
+		for _, index := range table.subIndices {
+			index.put(previous)
+		}
+		keeper.put(table, previous)
+		table.unretire(previous)
+	}
+}